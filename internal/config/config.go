@@ -1,17 +1,33 @@
 package config
 
 import (
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
 
 	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
-	Auth   `yaml:"auth"`
-	Server `yaml:"server"`
+	Auth        `yaml:"auth"`
+	Server      `yaml:"server"`
+	Camera      `yaml:"camera"`
+	TestPattern `yaml:"test_pattern"`
+	Playback    `yaml:"playback"`
+	HLS         `yaml:"hls"`
+	MJPEG       `yaml:"mjpeg"`
+	Recording   `yaml:"recording"`
+	DVR         `yaml:"dvr"`
+	Motion      `yaml:"motion"`
+	Clips       `yaml:"clips"`
+	Talkback    `yaml:"talkback"`
 }
 
+var bitratePattern = regexp.MustCompile(`^[0-9]+[kKmM]?$`)
+
 func LoadConfig() (c Config, err error) {
 	f, err := os.Open("config.yaml")
 	if err != nil {
@@ -28,5 +44,354 @@ func LoadConfig() (c Config, err error) {
 		return
 	}
 
+	c.setDefaults()
+	err = c.Validate()
 	return
 }
+
+func (c *Config) setDefaults() {
+	if c.Server.Receiver == "" {
+		c.Server.Receiver = ReceiverWebRTC
+		if c.Server.UseDirectCamera {
+			c.Server.Receiver = ReceiverCamera
+		}
+	}
+
+	if c.Camera.Width == 0 && c.Camera.Height == 0 {
+		c.Camera.Width, c.Camera.Height = 640, 480
+	}
+	if c.Camera.Framerate == 0 {
+		c.Camera.Framerate = 30
+	}
+	if c.Camera.KeyframeInterval == 0 {
+		c.Camera.KeyframeInterval = 2 * c.Camera.Framerate
+	}
+	if c.Camera.CaptureMode == "" {
+		c.Camera.CaptureMode = CaptureEncode
+	}
+	if c.Camera.Codec == "" {
+		c.Camera.Codec = CodecVP8
+	}
+	if c.Camera.Bitrate == "" {
+		c.Camera.Bitrate = "500k"
+	}
+	if c.Camera.CRF == 0 {
+		c.Camera.CRF = 40
+	}
+	if c.Camera.AudioBitrate == "" {
+		c.Camera.AudioBitrate = "32k"
+	}
+
+	if c.TestPattern.Pattern == "" {
+		c.TestPattern.Pattern = "testsrc"
+	}
+	if c.TestPattern.Width == 0 && c.TestPattern.Height == 0 {
+		c.TestPattern.Width, c.TestPattern.Height = 640, 480
+	}
+	if c.TestPattern.Framerate == 0 {
+		c.TestPattern.Framerate = 30
+	}
+	if c.TestPattern.ToneFrequency == 0 {
+		c.TestPattern.ToneFrequency = 440
+	}
+
+	if c.HLS.SegmentDuration == 0 {
+		c.HLS.SegmentDuration = 2
+	}
+	if c.HLS.PlaylistSize == 0 {
+		c.HLS.PlaylistSize = 6
+	}
+	if c.HLS.Bitrate == "" {
+		c.HLS.Bitrate = "1M"
+	}
+
+	if c.MJPEG.Framerate == 0 {
+		c.MJPEG.Framerate = 5
+	}
+	if c.MJPEG.Quality == 0 {
+		c.MJPEG.Quality = 5
+	}
+	if c.MJPEG.MaxClients == 0 {
+		c.MJPEG.MaxClients = 4
+	}
+	if c.MJPEG.SnapshotsPerMinute == 0 {
+		c.MJPEG.SnapshotsPerMinute = 60
+	}
+
+	if c.Recording.Directory == "" {
+		c.Recording.Directory = "recordings"
+	}
+	if c.Recording.SegmentDuration == 0 {
+		c.Recording.SegmentDuration = 600
+	}
+
+	if c.DVR.Duration == 0 {
+		c.DVR.Duration = 300
+	}
+	if c.DVR.MaxSizeMB == 0 {
+		c.DVR.MaxSizeMB = 128
+	}
+
+	if c.Motion.Width == 0 && c.Motion.Height == 0 {
+		c.Motion.Width, c.Motion.Height = 160, 120
+	}
+	if c.Motion.Framerate == 0 {
+		c.Motion.Framerate = 5
+	}
+	if c.Motion.Threshold == 0 {
+		c.Motion.Threshold = 25
+	}
+	if c.Motion.MinArea == 0 {
+		c.Motion.MinArea = 1
+	}
+	if c.Motion.MinFrames == 0 {
+		c.Motion.MinFrames = 2
+	}
+	if c.Motion.Cooldown == 0 {
+		c.Motion.Cooldown = 5
+	}
+
+	if c.Clips.Directory == "" {
+		c.Clips.Directory = "clips"
+	}
+	if c.Clips.PreRoll == 0 {
+		c.Clips.PreRoll = 5
+	}
+	if c.Clips.PostRoll == 0 {
+		c.Clips.PostRoll = 10
+	}
+	if c.Clips.MaxDuration == 0 {
+		c.Clips.MaxDuration = 300
+	}
+
+	if c.Talkback.MaxDuration == 0 {
+		c.Talkback.MaxDuration = 60
+	}
+}
+
+func (c Config) Validate() error {
+	switch c.Server.Receiver {
+	case ReceiverWebRTC, ReceiverCamera, ReceiverTestPattern:
+	case ReceiverFile:
+		if c.Playback.Path == "" {
+			return fmt.Errorf("invalid playback config: path is required for the file receiver")
+		}
+	default:
+		return fmt.Errorf("unknown receiver %q", c.Server.Receiver)
+	}
+	if err := c.Camera.Validate(); err != nil {
+		return fmt.Errorf("invalid camera config: %v", err)
+	}
+	if err := c.TestPattern.Validate(); err != nil {
+		return fmt.Errorf("invalid test pattern config: %v", err)
+	}
+	if err := c.HLS.Validate(); err != nil {
+		return fmt.Errorf("invalid hls config: %v", err)
+	}
+	if err := c.MJPEG.Validate(); err != nil {
+		return fmt.Errorf("invalid mjpeg config: %v", err)
+	}
+	if err := c.Recording.Validate(); err != nil {
+		return fmt.Errorf("invalid recording config: %v", err)
+	}
+	if err := c.DVR.Validate(); err != nil {
+		return fmt.Errorf("invalid dvr config: %v", err)
+	}
+	if err := c.Motion.Validate(); err != nil {
+		return fmt.Errorf("invalid motion config: %v", err)
+	}
+	if err := c.Clips.Validate(); err != nil {
+		return fmt.Errorf("invalid clips config: %v", err)
+	}
+	// Both list every sidecar in their directory and would take the other's
+	// files for their own
+	if filepath.Clean(c.Clips.Directory) == filepath.Clean(c.Recording.Directory) {
+		return fmt.Errorf("invalid clips config: directory must differ from the recording directory")
+	}
+	// The pre-roll is taken from the DVR buffer
+	if c.Clips.Enabled && (!c.DVR.Enabled || c.DVR.Duration < c.Clips.PreRoll+c.Clips.PostRoll) {
+		return fmt.Errorf("invalid clips config: clips need the dvr enabled with a duration of at least pre_roll plus post_roll")
+	}
+	if err := c.Talkback.Validate(); err != nil {
+		return fmt.Errorf("invalid talkback config: %v", err)
+	}
+	return nil
+}
+
+func (c Camera) Validate() error {
+	if c.Width <= 0 || c.Height <= 0 || c.Width%2 != 0 || c.Height%2 != 0 {
+		return fmt.Errorf("resolution must be positive and even, got %dx%d", c.Width, c.Height)
+	}
+	if c.Framerate <= 0 || c.Framerate > 120 {
+		return fmt.Errorf("framerate must be between 1 and 120, got %d", c.Framerate)
+	}
+	if c.KeyframeInterval <= 0 || c.KeyframeInterval > 300 {
+		return fmt.Errorf("keyframe_interval must be between 1 and 300 frames, got %d", c.KeyframeInterval)
+	}
+	switch c.CaptureMode {
+	case CaptureEncode, CaptureMJPEG, CapturePassthrough, CaptureAuto:
+	default:
+		return fmt.Errorf("capture_mode must be one of encode, mjpeg, passthrough or auto, got %q", c.CaptureMode)
+	}
+	if c.CaptureMode == CaptureMJPEG || c.CaptureMode == CapturePassthrough {
+		// Only V4L2 devices can be asked for their compressed formats
+		backend := c.Backend
+		if backend == "" && runtime.GOOS == "linux" {
+			backend = "v4l2"
+		}
+		if backend != "v4l2" {
+			return fmt.Errorf("capture_mode %s needs the v4l2 backend, got %q", c.CaptureMode, backend)
+		}
+	}
+	switch c.Codec {
+	case CodecVP8, CodecH264:
+	default:
+		return fmt.Errorf("codec must be vp8 or h264, got %q", c.Codec)
+	}
+	if !bitratePattern.MatchString(c.Bitrate) {
+		return fmt.Errorf("bitrate must be a number with an optional k or M suffix, got %q", c.Bitrate)
+	}
+	if c.CRF < 4 || c.CRF > 63 {
+		return fmt.Errorf("crf must be between 4 and 63, got %d", c.CRF)
+	}
+	switch c.Deadline {
+	case "", "best", "good", "realtime":
+	default:
+		return fmt.Errorf("deadline must be one of best, good or realtime, got %q", c.Deadline)
+	}
+	if c.CpuUsed < -16 || c.CpuUsed > 16 {
+		return fmt.Errorf("cpu_used must be between -16 and 16, got %d", c.CpuUsed)
+	}
+	if !bitratePattern.MatchString(c.AudioBitrate) {
+		return fmt.Errorf("audio_bitrate must be a number with an optional k or M suffix, got %q", c.AudioBitrate)
+	}
+	return nil
+}
+
+func (t TestPattern) Validate() error {
+	switch t.Pattern {
+	case "testsrc", "testsrc2", "smptebars", "smptehdbars", "rgbtestsrc":
+	default:
+		return fmt.Errorf("unknown pattern %q", t.Pattern)
+	}
+	if t.Width <= 0 || t.Height <= 0 || t.Width%2 != 0 || t.Height%2 != 0 {
+		return fmt.Errorf("resolution must be positive and even, got %dx%d", t.Width, t.Height)
+	}
+	if t.Framerate <= 0 || t.Framerate > 120 {
+		return fmt.Errorf("framerate must be between 1 and 120, got %d", t.Framerate)
+	}
+	if t.ToneFrequency <= 0 || t.ToneFrequency > 20000 {
+		return fmt.Errorf("tone_frequency must be between 1 and 20000, got %d", t.ToneFrequency)
+	}
+	return nil
+}
+
+func (h HLS) Validate() error {
+	if h.SegmentDuration <= 0 || h.SegmentDuration > 10 {
+		return fmt.Errorf("segment_duration must be between 1 and 10 seconds, got %d", h.SegmentDuration)
+	}
+	if h.PlaylistSize < 2 {
+		return fmt.Errorf("playlist_size must be at least 2 segments, got %d", h.PlaylistSize)
+	}
+	if !bitratePattern.MatchString(h.Bitrate) {
+		return fmt.Errorf("bitrate must be a number with an optional k or M suffix, got %q", h.Bitrate)
+	}
+	return nil
+}
+
+func (m MJPEG) Validate() error {
+	if m.Framerate <= 0 || m.Framerate > 30 {
+		return fmt.Errorf("framerate must be between 1 and 30, got %d", m.Framerate)
+	}
+	if m.Width < 0 || m.Width%2 != 0 {
+		return fmt.Errorf("width must be even, or 0 to keep the source width, got %d", m.Width)
+	}
+	if m.Quality < 2 || m.Quality > 31 {
+		return fmt.Errorf("quality must be between 2 and 31, got %d", m.Quality)
+	}
+	if m.MaxClients <= 0 {
+		return fmt.Errorf("max_clients must be positive, got %d", m.MaxClients)
+	}
+	if m.SnapshotsPerMinute <= 0 {
+		return fmt.Errorf("snapshots_per_minute must be positive, got %d", m.SnapshotsPerMinute)
+	}
+	return nil
+}
+
+func (r Recording) Validate() error {
+	if r.SegmentDuration < 10 || r.SegmentDuration > 3600 {
+		return fmt.Errorf("segment_duration must be between 10 and 3600 seconds, got %d", r.SegmentDuration)
+	}
+	if r.MaxAgeDays < 0 {
+		return fmt.Errorf("max_age_days must not be negative, got %d", r.MaxAgeDays)
+	}
+	if r.MaxSizeMB < 0 {
+		return fmt.Errorf("max_size_mb must not be negative, got %d", r.MaxSizeMB)
+	}
+	return nil
+}
+
+func (d DVR) Validate() error {
+	if d.Duration < 10 || d.Duration > 3600 {
+		return fmt.Errorf("duration must be between 10 and 3600 seconds, got %d", d.Duration)
+	}
+	if d.MaxSizeMB <= 0 {
+		return fmt.Errorf("max_size_mb must be positive, got %d", d.MaxSizeMB)
+	}
+	return nil
+}
+
+func (m Motion) Validate() error {
+	if m.Width <= 0 || m.Height <= 0 || m.Width > 640 || m.Height > 480 {
+		return fmt.Errorf("resolution must be positive and at most 640x480, got %dx%d", m.Width, m.Height)
+	}
+	if m.Framerate <= 0 || m.Framerate > 30 {
+		return fmt.Errorf("framerate must be between 1 and 30, got %d", m.Framerate)
+	}
+	if m.Threshold <= 0 || m.Threshold > 255 {
+		return fmt.Errorf("threshold must be between 1 and 255, got %d", m.Threshold)
+	}
+	if m.MinArea <= 0 || m.MinArea > 100 {
+		return fmt.Errorf("min_area must be above 0 and at most 100 percent, got %g", m.MinArea)
+	}
+	if m.MinFrames <= 0 {
+		return fmt.Errorf("min_frames must be positive, got %d", m.MinFrames)
+	}
+	if m.Cooldown <= 0 {
+		return fmt.Errorf("cooldown must be positive, got %d", m.Cooldown)
+	}
+	for i, mask := range m.Masks {
+		if mask.X < 0 || mask.Y < 0 || mask.Width <= 0 || mask.Height <= 0 || mask.X+mask.Width > 1 || mask.Y+mask.Height > 1 {
+			return fmt.Errorf("mask %d must lie within the frame, in fractions between 0 and 1", i)
+		}
+	}
+	return nil
+}
+
+func (c Clips) Validate() error {
+	if c.PreRoll < 0 || c.PreRoll > 300 {
+		return fmt.Errorf("pre_roll must be between 0 and 300 seconds, got %d", c.PreRoll)
+	}
+	if c.PostRoll < 0 || c.PostRoll > 300 {
+		return fmt.Errorf("post_roll must be between 0 and 300 seconds, got %d", c.PostRoll)
+	}
+	if c.MaxDuration < 10 || c.MaxDuration > 3600 {
+		return fmt.Errorf("max_duration must be between 10 and 3600 seconds, got %d", c.MaxDuration)
+	}
+	if c.MaxAgeDays < 0 {
+		return fmt.Errorf("max_age_days must not be negative, got %d", c.MaxAgeDays)
+	}
+	return nil
+}
+
+func (t Talkback) Validate() error {
+	switch t.Output {
+	case "", "alsa", "pulse", "audiotoolbox":
+	default:
+		return fmt.Errorf("output must be one of alsa, pulse or audiotoolbox, got %q", t.Output)
+	}
+	if t.MaxDuration <= 0 || t.MaxDuration > 600 {
+		return fmt.Errorf("max_duration must be between 1 and 600 seconds, got %d", t.MaxDuration)
+	}
+	return nil
+}
@@ -1,5 +1,25 @@
 package config
 
+const (
+	ReceiverWebRTC      = "webrtc"
+	ReceiverCamera      = "camera"
+	ReceiverTestPattern = "testpattern"
+	ReceiverFile        = "file"
+)
+
+const (
+	CodecVP8  = "vp8"
+	CodecH264 = "h264"
+)
+
+// Ways the camera receiver gets its video from the device
+const (
+	CaptureEncode      = "encode"      // Raw frames encoded to camera.codec
+	CaptureMJPEG       = "mjpeg"       // MJPEG decoded and encoded to camera.codec
+	CapturePassthrough = "passthrough" // The device's H.264 relayed as it is
+	CaptureAuto        = "auto"        // Passthrough, else MJPEG, else encode
+)
+
 type Auth struct {
 	JwtSecretKey   string `yaml:"jwt_secret_key"`
 	ExpirationTime int    `yaml:"expiration_time"`
@@ -8,6 +28,7 @@ type Auth struct {
 
 type Server struct {
 	UseDirectCamera bool   `yaml:"use_direct_camera"`
+	Receiver        string `yaml:"receiver"`
 	Host            string `yaml:"host"`
 	Port            int    `yaml:"port"`
 }
@@ -16,3 +37,112 @@ type User struct {
 	Username       string `yaml:"username"`
 	HashedPassword string `yaml:"hashed_password"`
 }
+
+type Camera struct {
+	Device           string   `yaml:"device"`
+	Backend          string   `yaml:"backend"`
+	Width            int      `yaml:"width"`
+	Height           int      `yaml:"height"`
+	Framerate        int      `yaml:"framerate"`
+	KeyframeInterval int      `yaml:"keyframe_interval"`
+	CaptureMode      string   `yaml:"capture_mode"`
+	Codec            string   `yaml:"codec"`
+	Encoder          string   `yaml:"encoder"`
+	Bitrate          string   `yaml:"bitrate"`
+	CRF              int      `yaml:"crf"`
+	Deadline         string   `yaml:"deadline"`
+	CpuUsed          int      `yaml:"cpu_used"`
+	ExtraArgs        []string `yaml:"extra_args"`
+	Audio            bool     `yaml:"audio"`
+	AudioDevice      string   `yaml:"audio_device"`
+	AudioBackend     string   `yaml:"audio_backend"`
+	AudioBitrate     string   `yaml:"audio_bitrate"`
+}
+
+type TestPattern struct {
+	Pattern       string `yaml:"pattern"`
+	Width         int    `yaml:"width"`
+	Height        int    `yaml:"height"`
+	Framerate     int    `yaml:"framerate"`
+	Audio         bool   `yaml:"audio"`
+	ToneFrequency int    `yaml:"tone_frequency"`
+}
+
+type Playback struct {
+	Path string `yaml:"path"`
+	Loop bool   `yaml:"loop"`
+}
+
+type HLS struct {
+	Enabled         bool   `yaml:"enabled"`
+	Directory       string `yaml:"directory"`
+	SegmentDuration int    `yaml:"segment_duration"`
+	PlaylistSize    int    `yaml:"playlist_size"`
+	LowLatency      bool   `yaml:"low_latency"`
+	Bitrate         string `yaml:"bitrate"`
+	Audio           bool   `yaml:"audio"`
+}
+
+type MJPEG struct {
+	Enabled            bool `yaml:"enabled"`
+	Framerate          int  `yaml:"framerate"`
+	Width              int  `yaml:"width"`
+	Quality            int  `yaml:"quality"`
+	MaxClients         int  `yaml:"max_clients"`
+	SnapshotsPerMinute int  `yaml:"snapshots_per_minute"`
+}
+
+type Recording struct {
+	Enabled         bool   `yaml:"enabled"`
+	Directory       string `yaml:"directory"`
+	SegmentDuration int    `yaml:"segment_duration"`
+	Audio           bool   `yaml:"audio"`
+	MaxAgeDays      int    `yaml:"max_age_days"`
+	MaxSizeMB       int64  `yaml:"max_size_mb"`
+}
+
+type DVR struct {
+	Enabled   bool `yaml:"enabled"`
+	Duration  int  `yaml:"duration"`
+	MaxSizeMB int  `yaml:"max_size_mb"`
+}
+
+type Motion struct {
+	Enabled   bool     `yaml:"enabled"`
+	Width     int      `yaml:"width"`
+	Height    int      `yaml:"height"`
+	Framerate int      `yaml:"framerate"`
+	Threshold int      `yaml:"threshold"`
+	MinArea   float64  `yaml:"min_area"`
+	MinFrames int      `yaml:"min_frames"`
+	Cooldown  int      `yaml:"cooldown"`
+	Masks     []Region `yaml:"masks"`
+}
+
+// Region is a rectangle in fractions of the frame size, so it does not depend
+// on the resolution.
+type Region struct {
+	X      float64 `yaml:"x"`
+	Y      float64 `yaml:"y"`
+	Width  float64 `yaml:"width"`
+	Height float64 `yaml:"height"`
+}
+
+type Clips struct {
+	Enabled     bool   `yaml:"enabled"`
+	Directory   string `yaml:"directory"`
+	PreRoll     int    `yaml:"pre_roll"`
+	PostRoll    int    `yaml:"post_roll"`
+	MaxDuration int    `yaml:"max_duration"`
+	ManualOnly  bool   `yaml:"manual_only"`
+	Audio       bool   `yaml:"audio"`
+	MaxAgeDays  int    `yaml:"max_age_days"`
+}
+
+type Talkback struct {
+	Enabled     bool     `yaml:"enabled"`
+	Output      string   `yaml:"output"`
+	Device      string   `yaml:"device"`
+	Command     []string `yaml:"command"`
+	MaxDuration int      `yaml:"max_duration"`
+}
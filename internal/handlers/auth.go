@@ -155,6 +155,38 @@ func (ac *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// RequireJWT only passes requests carrying a valid JWT on to next, either as
+// a Bearer token or in the jwt cookie set by Login. CORS preflight requests
+// are passed on as they never carry credentials.
+func (ac *AuthHandler) RequireJWT(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			next(w, r)
+			return
+		}
+
+		token := r.Header.Get("Authorization")
+		if token == "" {
+			if cookie, err := r.Cookie("jwt"); err == nil {
+				token = cookie.Value
+			}
+		}
+
+		if token != "" {
+			if ok, err := ac.authorizer.VerifyJWT(token); err == nil && ok {
+				next(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+	}
+}
+
 func (ac *AuthHandler) ProtectedEndpoint(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
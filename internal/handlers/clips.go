@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"katkam/internal/infrastructure/recording"
+	"net/http"
+	"time"
+)
+
+// maxTriggerDuration bounds the duration of manually triggered clips
+const maxTriggerDuration = 10 * time.Minute
+
+type ClipsHandler struct {
+	directory string
+	clipper   *recording.Clipper
+}
+
+// NewClipsHandler creates the handler, clipper is nil when clips are
+// disabled, the saved clips can still be browsed then.
+func NewClipsHandler(directory string, clipper *recording.Clipper) *ClipsHandler {
+	return &ClipsHandler{
+		directory: directory,
+		clipper:   clipper,
+	}
+}
+
+type TriggerClipRequest struct {
+	Duration float64 `json:"duration"` // seconds
+}
+
+// Clips lists the saved clips on GET, optionally limited to the RFC 3339 from
+// and to query parameters, and triggers a clip on POST.
+func (ch *ClipsHandler) Clips(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, "GET", "POST") {
+		return
+	}
+
+	if r.Method == "POST" {
+		ch.trigger(w, r)
+		return
+	}
+
+	from, to, err := parseRange(r, time.Time{}, time.Now().Add(time.Hour))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	clips, err := recording.ClipsBetween(ch.directory, from, to)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"clips": clips})
+}
+
+func (ch *ClipsHandler) trigger(w http.ResponseWriter, r *http.Request) {
+	if ch.clipper == nil {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Clips are disabled"})
+		return
+	}
+
+	var triggerReq TriggerClipRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&triggerReq); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+	}
+	duration := time.Duration(triggerReq.Duration * float64(time.Second))
+	if duration < 0 || duration > maxTriggerDuration {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("duration must be between 0 and %.0f seconds", maxTriggerDuration.Seconds())})
+		return
+	}
+
+	clip, err := ch.clipper.Trigger(duration)
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(clip)
+}
+
+// Clip returns the metadata of a single clip on GET and deletes it on
+// DELETE, unless it is still being recorded.
+func (ch *ClipsHandler) Clip(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, "GET", "DELETE") {
+		return
+	}
+
+	clip, ok := ch.clip(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == "DELETE" {
+		if ch.clipper != nil && ch.clipper.Writing(clip.ID) {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Clip is still being recorded"})
+			return
+		}
+		if err := recording.DeleteClip(ch.directory, clip.ID); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"message": "Clip deleted"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(clip)
+}
+
+// Video downloads the VP8 or H.264 video of a clip as IVF.
+func (ch *ClipsHandler) Video(w http.ResponseWriter, r *http.Request) {
+	ch.download(w, r, recording.Clip.VideoPath, "video/x-ivf", recording.VideoExtension)
+}
+
+// Audio downloads the Opus audio of a clip as Ogg.
+func (ch *ClipsHandler) Audio(w http.ResponseWriter, r *http.Request) {
+	ch.download(w, r, recording.Clip.AudioPath, "audio/ogg", recording.AudioExtension)
+}
+
+// Thumbnail serves the JPEG thumbnail of a clip.
+func (ch *ClipsHandler) Thumbnail(w http.ResponseWriter, r *http.Request) {
+	ch.download(w, r, recording.Clip.ThumbnailPath, "image/jpeg", recording.ThumbnailExtension)
+}
+
+func (ch *ClipsHandler) download(w http.ResponseWriter, r *http.Request, file func(recording.Clip, string) string, contentType, extension string) {
+	if !allowMethod(w, r, "GET") {
+		return
+	}
+
+	clip, ok := ch.clip(w, r)
+	if !ok {
+		return
+	}
+	if (extension == recording.AudioExtension && !clip.HasAudio) || (extension == recording.ThumbnailExtension && !clip.Thumbnail) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Clip has no such file"})
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if extension != recording.ThumbnailExtension {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s%s"`, clip.ID, extension))
+	}
+	http.ServeFile(w, r, file(clip, ch.directory))
+}
+
+func (ch *ClipsHandler) clip(w http.ResponseWriter, r *http.Request) (recording.Clip, bool) {
+	clip, err := recording.GetClip(ch.directory, r.PathValue("id"))
+	if errors.Is(err, recording.ErrClipNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return clip, false
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return clip, false
+	}
+	return clip, true
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"katkam/internal/infrastructure/connectivity/sinks"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+const (
+	snapshotTimeout = 5 * time.Second
+	// Segmenting starts on a request, the first playlist needs a keyframe
+	// and a full segment
+	hlsStartTimeout = 20 * time.Second
+	mjpegBoundary   = "frame"
+)
+
+// MediaHandler serves the outputs derived from the relayed stream for
+// clients that do not speak WebRTC.
+type MediaHandler struct {
+	hls             *sinks.HLS
+	mjpeg           *sinks.MJPEG
+	snapshotLimiter *rateLimiter
+}
+
+// NewMediaHandler creates the handler, hls and mjpeg are nil when disabled.
+func NewMediaHandler(hls *sinks.HLS, mjpeg *sinks.MJPEG) *MediaHandler {
+	mh := &MediaHandler{
+		hls:   hls,
+		mjpeg: mjpeg,
+	}
+	if mjpeg != nil {
+		mh.snapshotLimiter = newRateLimiter(mjpeg.Config.SnapshotsPerMinute, time.Minute)
+	}
+	return mh
+}
+
+// HLS serves the playlists and segments below /hls/.
+func (mh *MediaHandler) HLS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if mh.hls == nil {
+		http.Error(w, "HLS is disabled", http.StatusNotFound)
+		return
+	}
+
+	name := r.PathValue("file")
+	if name != filepath.Base(name) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	playlist := false
+	switch filepath.Ext(name) {
+	case ".m3u8":
+		// Playlists change with every segment
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+		playlist = true
+	case ".mpd":
+		w.Header().Set("Content-Type", "application/dash+xml")
+		w.Header().Set("Cache-Control", "no-cache")
+		playlist = true
+	case ".m4s":
+		w.Header().Set("Content-Type", "video/iso.segment")
+	case ".mp4":
+		w.Header().Set("Content-Type", "video/mp4")
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	mh.hls.Request()
+	if playlist {
+		// Playlists left behind by an idle run are stale
+		ctx, cancel := context.WithTimeout(r.Context(), hlsStartTimeout)
+		defer cancel()
+		if err := mh.hls.WaitReady(ctx); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	http.ServeFile(w, r, filepath.Join(mh.hls.Directory(), name))
+}
+
+// Snapshot serves the latest camera image as JPEG.
+func (mh *MediaHandler) Snapshot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if mh.mjpeg == nil {
+		http.Error(w, "Snapshots are disabled", http.StatusNotFound)
+		return
+	}
+
+	if !mh.snapshotLimiter.Allow(r.RemoteAddr) {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Too many snapshot requests", http.StatusTooManyRequests)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), snapshotTimeout)
+	defer cancel()
+
+	image, err := mh.mjpeg.Snapshot(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(image)
+}
+
+// MJPEG streams the camera as multipart JPEG images, which <img> tags play.
+func (mh *MediaHandler) MJPEG(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if mh.mjpeg == nil {
+		http.Error(w, "MJPEG is disabled", http.StatusNotFound)
+		return
+	}
+
+	images, unsubscribe, err := mh.mjpeg.Subscribe()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mjpegBoundary)
+	w.Header().Set("Cache-Control", "no-store")
+	controller := http.NewResponseController(w)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case image := <-images:
+			if _, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", mjpegBoundary, len(image)); err != nil {
+				return
+			}
+			if _, err := w.Write(image); err != nil {
+				return
+			}
+			if _, err := w.Write([]byte("\r\n")); err != nil {
+				return
+			}
+			if err := controller.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"katkam/internal/infrastructure/motion"
+	"net/http"
+	"time"
+)
+
+// defaultEventsWindow is how far back events are listed without a from
+const defaultEventsWindow = 24 * time.Hour
+
+type MotionHandler struct {
+	detector *motion.Detector
+}
+
+// NewMotionHandler creates the handler, detector is nil when disabled.
+func NewMotionHandler(detector *motion.Detector) *MotionHandler {
+	return &MotionHandler{
+		detector: detector,
+	}
+}
+
+// Events lists the motion events between the RFC 3339 times in the from and
+// to query parameters, the last day by default.
+func (mh *MotionHandler) Events(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	if mh.detector == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Motion detection is disabled"})
+		return
+	}
+
+	now := time.Now()
+	from, to, err := parseRange(r, now.Add(-defaultEventsWindow), now.Add(time.Minute))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"active": mh.detector.Active(),
+		"events": mh.detector.Events(from, to),
+	})
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// rateLimiter allows every client address a number of requests per window.
+type rateLimiter struct {
+	limit   int
+	window  time.Duration
+	mutex   sync.Mutex
+	clients map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start    time.Time
+	requests int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{
+		limit:   limit,
+		window:  window,
+		clients: make(map[string]*rateWindow),
+	}
+}
+
+func (l *rateLimiter) Allow(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	client, ok := l.clients[host]
+	if !ok || now.Sub(client.start) >= l.window {
+		l.forgetExpired(now)
+		client = &rateWindow{start: now}
+		l.clients[host] = client
+	}
+
+	if client.requests >= l.limit {
+		return false
+	}
+	client.requests++
+	return true
+}
+
+// forgetExpired drops the windows that ended, so the map only holds recent
+// clients. Must be called with mutex held.
+func (l *rateLimiter) forgetExpired(now time.Time) {
+	for host, client := range l.clients {
+		if now.Sub(client.start) >= l.window {
+			delete(l.clients, host)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"katkam/internal/infrastructure/recording"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+type RecordingsHandler struct {
+	directory string
+}
+
+func NewRecordingsHandler(directory string) *RecordingsHandler {
+	return &RecordingsHandler{
+		directory: directory,
+	}
+}
+
+type KeepRequest struct {
+	Kept bool `json:"kept"`
+}
+
+// List returns the recorded segments, optionally limited to those overlapping
+// the RFC 3339 times in the from and to query parameters.
+func (rh *RecordingsHandler) List(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, "GET") {
+		return
+	}
+
+	from, to, err := parseRange(r, time.Time{}, time.Now().Add(time.Hour))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	segments, err := recording.SegmentsBetween(rh.directory, from, to)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"segments": segments})
+}
+
+// Get returns the metadata of a single segment.
+func (rh *RecordingsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, "GET") {
+		return
+	}
+
+	segment, ok := rh.segment(w, r)
+	if !ok {
+		return
+	}
+	json.NewEncoder(w).Encode(segment)
+}
+
+// Video downloads the VP8 or H.264 video of a segment as IVF.
+func (rh *RecordingsHandler) Video(w http.ResponseWriter, r *http.Request) {
+	rh.download(w, r, recording.Segment.VideoPath, "video/x-ivf", recording.VideoExtension)
+}
+
+// Audio downloads the Opus audio of a segment as Ogg.
+func (rh *RecordingsHandler) Audio(w http.ResponseWriter, r *http.Request) {
+	rh.download(w, r, recording.Segment.AudioPath, "audio/ogg", recording.AudioExtension)
+}
+
+func (rh *RecordingsHandler) download(w http.ResponseWriter, r *http.Request, file func(recording.Segment, string) string, contentType, extension string) {
+	if !allowMethod(w, r, "GET") {
+		return
+	}
+
+	segment, ok := rh.segment(w, r)
+	if !ok {
+		return
+	}
+	if extension == recording.AudioExtension && !segment.HasAudio {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Segment has no audio"})
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s%s"`, segment.ID, extension))
+	http.ServeFile(w, r, file(segment, rh.directory))
+}
+
+// Keep marks a segment as kept, so the retention policy never deletes it,
+// or hands it back to the policy.
+func (rh *RecordingsHandler) Keep(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, "PUT") {
+		return
+	}
+
+	var keepReq KeepRequest
+	if err := json.NewDecoder(r.Body).Decode(&keepReq); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	segment, err := recording.SetKept(rh.directory, r.PathValue("id"), keepReq.Kept)
+	if errors.Is(err, recording.ErrSegmentNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(segment)
+}
+
+// Export stitches the recordings between the from and to query parameters
+// into a single file, format is mp4 (the default) or webm.
+func (rh *RecordingsHandler) Export(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, "GET") {
+		return
+	}
+
+	from, to, err := parseRange(r, time.Time{}, time.Time{})
+	if err == nil && (from.IsZero() || to.IsZero()) {
+		err = errors.New("from and to are required")
+	}
+	if err == nil && to.Sub(from) > recording.MaxExportDuration {
+		err = fmt.Errorf("at most %s can be exported at once", recording.MaxExportDuration)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = recording.ExportMP4
+	}
+	if format != recording.ExportMP4 && format != recording.ExportWebM {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "format must be mp4 or webm"})
+		return
+	}
+
+	segments, err := recording.SegmentsBetween(rh.directory, from, to)
+	if err == nil && len(segments) == 0 {
+		err = recording.ErrNothingRecorded
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	if _, err := recording.VideoCodec(segments); err != nil {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	// The file is streamed while ffmpeg writes it, failures past this point
+	// can only cut the download short
+	contentType := "video/mp4"
+	if format == recording.ExportWebM {
+		contentType = "video/webm"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="katkam_%s.%s"`, from.Local().Format("2006-01-02_15-04-05"), format))
+
+	if err := recording.Export(r.Context(), rh.directory, from, to, format, w); err != nil {
+		fmt.Printf("❌ Export failed: %v\n", err)
+	}
+}
+
+func (rh *RecordingsHandler) segment(w http.ResponseWriter, r *http.Request) (recording.Segment, bool) {
+	segment, err := recording.GetSegment(rh.directory, r.PathValue("id"))
+	if errors.Is(err, recording.ErrSegmentNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return segment, false
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return segment, false
+	}
+	return segment, true
+}
+
+// allowMethod sets the CORS and content type headers and rejects requests
+// with other methods than the given ones. It returns false when the request
+// was answered.
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(append(methods, "OPTIONS"), ", "))
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return false
+	}
+
+	if !slices.Contains(methods, r.Method) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed"})
+		return false
+	}
+	return true
+}
+
+// parseRange reads the RFC 3339 from and to query parameters, falling back to
+// the given defaults.
+func parseRange(r *http.Request, defaultFrom, defaultTo time.Time) (from, to time.Time, err error) {
+	from, to = defaultFrom, defaultTo
+
+	if value := r.URL.Query().Get("from"); value != "" {
+		if from, err = time.Parse(time.RFC3339, value); err != nil {
+			return from, to, fmt.Errorf("invalid from: %v", err)
+		}
+	}
+	if value := r.URL.Query().Get("to"); value != "" {
+		if to, err = time.Parse(time.RFC3339, value); err != nil {
+			return from, to, fmt.Errorf("invalid to: %v", err)
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && !to.After(from) {
+		return from, to, errors.New("to must be after from")
+	}
+	return from, to, nil
+}
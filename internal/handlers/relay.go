@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"encoding/json"
+	"katkam/internal/infrastructure/connectivity"
 	"katkam/internal/infrastructure/connectivity/relay"
 	"net/http"
 )
@@ -19,6 +21,48 @@ func (rh *RelayHandler) Start(w http.ResponseWriter, r *http.Request) {
 	rh.relay.Start()
 }
 
+func (rh *RelayHandler) Status(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(rh.relay.GetStatus())
+}
+
+// Health reports whether the relay runs and the source is connected, without
+// authentication for health checks. Everything else is in Status.
+func (rh *RelayHandler) Health(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(rh.relay.GetHealth())
+}
+
 func (rh *RelayHandler) HandleReceiverSignaling(w http.ResponseWriter, r *http.Request) {
 	rh.relay.GetReceiver().HandleWebSocketConnection(w, r)
 }
@@ -26,3 +70,24 @@ func (rh *RelayHandler) HandleReceiverSignaling(w http.ResponseWriter, r *http.R
 func (rh *RelayHandler) HandleSenderSignaling(w http.ResponseWriter, r *http.Request) {
 	rh.relay.GetSender().HandleWebSocketConnection(w, r)
 }
+
+// HandleWHIP lets WHIP clients publish into the relay, if the receiver
+// accepts publishers.
+func (rh *RelayHandler) HandleWHIP(w http.ResponseWriter, r *http.Request) {
+	signaler, ok := rh.relay.GetReceiver().(connectivity.HTTPSignaler)
+	if !ok {
+		http.Error(w, "Receiver does not accept WHIP publishers", http.StatusConflict)
+		return
+	}
+	signaler.HandleHTTPSignaling(w, r)
+}
+
+// HandleWHEP lets WHEP players watch the relayed stream.
+func (rh *RelayHandler) HandleWHEP(w http.ResponseWriter, r *http.Request) {
+	signaler, ok := rh.relay.GetSender().(connectivity.HTTPSignaler)
+	if !ok {
+		http.Error(w, "Sender does not accept WHEP viewers", http.StatusConflict)
+		return
+	}
+	signaler.HandleHTTPSignaling(w, r)
+}
@@ -0,0 +1,70 @@
+package connectivity
+
+import (
+	"katkam/internal/infrastructure/media/h264"
+	"time"
+)
+
+// opusClockRate is the rate of Opus timestamps and Ogg granule positions,
+// whatever the sample rate of the encoded audio
+const opusClockRate = 48000
+
+// IsKeyframe reports whether data, a video frame in codec, can be decoded
+// without the frames before it. H.264 frames are Annex-B access units.
+func IsKeyframe(codec string, data []byte) bool {
+	switch codec {
+	case CodecVP8:
+		return IsVP8Keyframe(data)
+	case CodecH264:
+		return h264.IsKeyframe(data)
+	}
+	return false
+}
+
+// Dimensions reads the frame size from a keyframe in codec.
+func Dimensions(codec string, data []byte) (width, height uint16, ok bool) {
+	switch codec {
+	case CodecVP8:
+		return VP8Dimensions(data)
+	case CodecH264:
+		return h264.Dimensions(data)
+	}
+	return 0, 0, false
+}
+
+// IsVP8Keyframe reports whether data is a VP8 keyframe, signalled by a
+// cleared lowest bit in the first byte of the frame tag.
+func IsVP8Keyframe(data []byte) bool {
+	return len(data) > 0 && data[0]&0x01 == 0
+}
+
+// VP8Dimensions reads the frame size from the header of a VP8 keyframe,
+// which follows the frame tag and the 0x9d 0x01 0x2a start code.
+func VP8Dimensions(data []byte) (width, height uint16, ok bool) {
+	if !IsVP8Keyframe(data) || len(data) < 10 || data[3] != 0x9d || data[4] != 0x01 || data[5] != 0x2a {
+		return 0, 0, false
+	}
+	// The upper two bits of each dimension are a scaling hint
+	width = (uint16(data[6]) | uint16(data[7])<<8) & 0x3fff
+	height = (uint16(data[8]) | uint16(data[9])<<8) & 0x3fff
+	return width, height, true
+}
+
+// OggTimestamp converts the PTS of an Opus frame to the RTP timestamp
+// oggwriter derives the granule position from. oggwriter takes a previous
+// timestamp of 1 for no packet written yet and would not advance on the
+// second packet, so timestamps start at 2. They wrap like RTP timestamps,
+// which oggwriter handles.
+func OggTimestamp(pts time.Duration) uint32 {
+	// Whole seconds and the remainder apart, nanoseconds times the clock
+	// rate overflow after two days
+	seconds, remainder := uint64(pts/time.Second), uint64(pts%time.Second)
+	return 2 + uint32(seconds*opusClockRate+remainder*opusClockRate/uint64(time.Second))
+}
+
+// OpusDuration converts a number of Opus samples, e.g. an Ogg granule
+// position, to a duration.
+func OpusDuration(samples uint64) time.Duration {
+	seconds, remainder := samples/opusClockRate, samples%opusClockRate
+	return time.Duration(seconds)*time.Second + time.Duration(remainder)*time.Second/opusClockRate
+}
@@ -0,0 +1,157 @@
+package receivers
+
+import (
+	"fmt"
+	"katkam/internal/infrastructure/connectivity"
+	"strings"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	ext_webrtc "github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/samplebuilder"
+)
+
+const (
+	// Packets are held back at most this long waiting for reordered packets
+	videoReorderDelay = 300 * time.Millisecond
+	audioReorderDelay = 100 * time.Millisecond
+
+	videoMaxLatePackets = 256
+	audioMaxLatePackets = 16
+)
+
+// frameAssembler reorders the RTP packets of a remote track and rebuilds the
+// complete frames they carry, stripping the payload descriptors. Packet loss
+// is detected from gaps in the released sequence numbers; after a loss, video
+// frames are held back until the next keyframe so viewers never decode
+// frames referencing missing data.
+type frameAssembler struct {
+	builder  *samplebuilder.SampleBuilder
+	timeline rtpTimeline
+	codec    string
+	isVideo  bool
+
+	lastSequence     uint16
+	released         bool
+	lostPackets      uint64
+	pendingLoss      bool
+	awaitingKeyframe bool
+}
+
+func newFrameAssembler(codec ext_webrtc.RTPCodecParameters) (*frameAssembler, error) {
+	a := &frameAssembler{
+		timeline:         rtpTimeline{clockRate: codec.ClockRate},
+		codec:            codec.MimeType,
+		awaitingKeyframe: true,
+	}
+
+	var depacketizer rtp.Depacketizer
+	maxLate, delay := uint16(audioMaxLatePackets), audioReorderDelay
+	switch {
+	case strings.EqualFold(codec.MimeType, ext_webrtc.MimeTypeVP8):
+		depacketizer = &codecs.VP8Packet{}
+		maxLate, delay = videoMaxLatePackets, videoReorderDelay
+		a.codec = connectivity.CodecVP8
+		a.isVideo = true
+	case strings.EqualFold(codec.MimeType, ext_webrtc.MimeTypeH264):
+		// Rebuilds Annex-B access units, with start codes
+		depacketizer = &codecs.H264Packet{}
+		maxLate, delay = videoMaxLatePackets, videoReorderDelay
+		a.codec = connectivity.CodecH264
+		a.isVideo = true
+	case strings.EqualFold(codec.MimeType, ext_webrtc.MimeTypeOpus):
+		depacketizer = &codecs.OpusPacket{}
+		a.codec = connectivity.CodecOpus
+	default:
+		return nil, fmt.Errorf("unsupported codec %s", codec.MimeType)
+	}
+
+	a.builder = samplebuilder.New(maxLate, depacketizer, codec.ClockRate,
+		samplebuilder.WithMaxTimeDelay(delay),
+		samplebuilder.WithPacketReleaseHandler(a.onPacketReleased),
+	)
+
+	return a, nil
+}
+
+// onPacketReleased sees every packet leaving the reorder buffer in sequence
+// order, so any gap between them is a packet that never arrived in time.
+func (a *frameAssembler) onPacketReleased(packet *rtp.Packet) {
+	if a.released {
+		if gap := packet.SequenceNumber - a.lastSequence - 1; gap != 0 && gap < 0x8000 {
+			a.lostPackets += uint64(gap)
+			a.pendingLoss = true
+		}
+	}
+	a.released = true
+	a.lastSequence = packet.SequenceNumber
+}
+
+// Push adds a packet and returns the frames it completed. lost reports that
+// packets went missing since the previous call.
+func (a *frameAssembler) Push(packet *rtp.Packet) (frames []connectivity.Frame, lost bool) {
+	a.builder.Push(packet)
+
+	for sample := a.builder.Pop(); sample != nil; sample = a.builder.Pop() {
+		// Dropped packets are either missing ones, already counted as a gap,
+		// or the rest of a frame that lost its head
+		if sample.PrevDroppedPackets > 0 {
+			a.pendingLoss = true
+		}
+		if a.pendingLoss {
+			lost = true
+			a.pendingLoss = false
+			a.awaitingKeyframe = a.isVideo
+		}
+
+		frame := connectivity.Frame{
+			Data:     sample.Data,
+			PTS:      a.timeline.PTS(sample.PacketTimestamp),
+			Duration: sample.Duration,
+			Keyframe: !a.isVideo || connectivity.IsKeyframe(a.codec, sample.Data),
+			Codec:    a.codec,
+		}
+
+		if a.awaitingKeyframe {
+			if !frame.Keyframe {
+				continue
+			}
+			a.awaitingKeyframe = false
+		}
+
+		frames = append(frames, frame)
+	}
+
+	return frames, lost
+}
+
+func (a *frameAssembler) LostPackets() uint64 {
+	return a.lostPackets
+}
+
+// rtpTimeline converts the 32 bit RTP timestamps of a track to durations since
+// its first packet, handling wrap around.
+type rtpTimeline struct {
+	clockRate uint32
+	started   bool
+	last      uint32
+	elapsed   int64
+}
+
+func (t *rtpTimeline) PTS(timestamp uint32) time.Duration {
+	if !t.started || t.clockRate == 0 {
+		t.started = true
+		t.last = timestamp
+		return 0
+	}
+
+	t.elapsed += int64(int32(timestamp - t.last))
+	t.last = timestamp
+
+	// Whole seconds and the remainder apart, ticks times a second in
+	// nanoseconds overflows after a day at 90kHz
+	rate := int64(t.clockRate)
+	seconds, remainder := t.elapsed/rate, t.elapsed%rate
+	return time.Duration(seconds)*time.Second + time.Duration(remainder)*time.Second/time.Duration(rate)
+}
@@ -0,0 +1,293 @@
+package receivers
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"katkam/internal/infrastructure/connectivity"
+
+	"github.com/pion/rtp"
+	ext_webrtc "github.com/pion/webrtc/v3"
+)
+
+var (
+	vp8Codec  = ext_webrtc.RTPCodecParameters{RTPCodecCapability: ext_webrtc.RTPCodecCapability{MimeType: ext_webrtc.MimeTypeVP8, ClockRate: 90000}}
+	opusCodec = ext_webrtc.RTPCodecParameters{RTPCodecCapability: ext_webrtc.RTPCodecCapability{MimeType: ext_webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}}
+)
+
+// vp8Ticks is a frame interval of 30fps in RTP ticks
+const vp8Ticks = 3000
+
+func TestRTPTimelinePTS(t *testing.T) {
+	tests := []struct {
+		name       string
+		clockRate  uint32
+		timestamps []uint32
+		want       []time.Duration
+	}{
+		{
+			name:       "video",
+			clockRate:  90000,
+			timestamps: []uint32{1000, 1000 + 3000, 1000 + 90000},
+			want:       []time.Duration{0, 33333333, time.Second},
+		},
+		{
+			name:       "wrap around",
+			clockRate:  90000,
+			timestamps: []uint32{0xffffff00, 0x00000100, 0x00000100 + 90000},
+			want:       []time.Duration{0, 512 * time.Second / 90000, 512*time.Second/90000 + time.Second},
+		},
+		{
+			name:       "reordered",
+			clockRate:  48000,
+			timestamps: []uint32{48000, 96000, 72000},
+			want:       []time.Duration{0, time.Second, time.Second / 2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			timeline := rtpTimeline{clockRate: test.clockRate}
+			for i, timestamp := range test.timestamps {
+				if pts := timeline.PTS(timestamp); pts != test.want[i] {
+					t.Errorf("timestamp %d: PTS %v, want %v", i, pts, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+// A publisher streaming for days passes the point where ticks times a second
+// in nanoseconds overflows, PTS must keep counting up.
+func TestRTPTimelineLongRun(t *testing.T) {
+	for _, clockRate := range []uint32{90000, 48000} {
+		timeline := rtpTimeline{clockRate: clockRate}
+		timestamp := uint32(12345)
+		timeline.PTS(timestamp)
+
+		// Steps below half the 32 bit range, many wraps in 3 days
+		const step = 1 << 30
+		var elapsed int64
+		previous := time.Duration(0)
+		for elapsed < int64(clockRate)*3*24*3600 {
+			timestamp += step
+			elapsed += step
+			pts := timeline.PTS(timestamp)
+
+			want := new(big.Int).Mul(big.NewInt(elapsed), big.NewInt(int64(time.Second)))
+			want.Quo(want, big.NewInt(int64(clockRate)))
+			if pts != time.Duration(want.Int64()) {
+				t.Fatalf("%dHz after %d ticks: PTS %v, want %v", clockRate, elapsed, pts, time.Duration(want.Int64()))
+			}
+			if pts <= previous {
+				t.Fatalf("%dHz after %d ticks: PTS went from %v to %v", clockRate, elapsed, previous, pts)
+			}
+			previous = pts
+		}
+	}
+}
+
+// vp8Packets splits a VP8 frame into the given number of RTP packets.
+func vp8Packets(sequence uint16, timestamp uint32, keyframe bool, packets int) []*rtp.Packet {
+	var result []*rtp.Packet
+	for i := 0; i < packets; i++ {
+		descriptor := byte(0x00)
+		if i == 0 {
+			descriptor = 0x10 // Start of partition 0
+		}
+		payload := []byte{descriptor, 0x01, 0x00, 0x00, byte(i)}
+		if i == 0 && keyframe {
+			// Frame tag with the keyframe bit cleared and the start code
+			payload = []byte{descriptor, 0x00, 0x00, 0x00, 0x9d, 0x01, 0x2a}
+		}
+		result = append(result, &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				SequenceNumber: sequence + uint16(i),
+				Timestamp:      timestamp,
+				Marker:         i == packets-1,
+			},
+			Payload: payload,
+		})
+	}
+	return result
+}
+
+// vp8Stream returns the packets of frames, two per frame, with keyframes at
+// the given frame indexes.
+func vp8Stream(frames int, keyframes ...int) [][]*rtp.Packet {
+	isKeyframe := map[int]bool{}
+	for _, index := range keyframes {
+		isKeyframe[index] = true
+	}
+
+	var stream [][]*rtp.Packet
+	for i := 0; i < frames; i++ {
+		stream = append(stream, vp8Packets(uint16(100+2*i), uint32(5000+i*vp8Ticks), isKeyframe[i], 2))
+	}
+	return stream
+}
+
+func pushAll(t *testing.T, assembler *frameAssembler, packets []*rtp.Packet) (frames []connectivity.Frame, lost bool) {
+	t.Helper()
+	for _, packet := range packets {
+		pushed, pushLost := assembler.Push(packet)
+		frames = append(frames, pushed...)
+		lost = lost || pushLost
+	}
+	return frames, lost
+}
+
+func framePTS(frames []connectivity.Frame) []time.Duration {
+	var pts []time.Duration
+	for _, frame := range frames {
+		pts = append(pts, frame.PTS)
+	}
+	return pts
+}
+
+func ptsOf(indexes ...int) []time.Duration {
+	var pts []time.Duration
+	for _, index := range indexes {
+		pts = append(pts, time.Duration(index)*vp8Ticks*time.Second/90000)
+	}
+	return pts
+}
+
+func equalPTS(a, b []time.Duration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func newTestAssembler(t *testing.T, codec ext_webrtc.RTPCodecParameters) *frameAssembler {
+	t.Helper()
+	assembler, err := newFrameAssembler(codec)
+	if err != nil {
+		t.Fatalf("newFrameAssembler: %v", err)
+	}
+	return assembler
+}
+
+func TestFrameAssemblerInOrder(t *testing.T) {
+	assembler := newTestAssembler(t, vp8Codec)
+	var packets []*rtp.Packet
+	for _, frame := range vp8Stream(5, 0) {
+		packets = append(packets, frame...)
+	}
+
+	frames, lost := pushAll(t, assembler, packets)
+	// The last frame is only released once a later packet arrives
+	if want := ptsOf(0, 1, 2, 3); !equalPTS(framePTS(frames), want) {
+		t.Fatalf("frames at %v, want %v", framePTS(frames), want)
+	}
+	if lost || assembler.LostPackets() != 0 {
+		t.Errorf("reported %d lost packets", assembler.LostPackets())
+	}
+	if !frames[0].Keyframe || frames[1].Keyframe || frames[0].Codec != connectivity.CodecVP8 {
+		t.Errorf("frame 0 keyframe %v, frame 1 keyframe %v, codec %s", frames[0].Keyframe, frames[1].Keyframe, frames[0].Codec)
+	}
+	// Payload descriptors are stripped
+	if data := frames[1].Data; len(data) != 8 || data[0] != 0x01 {
+		t.Errorf("frame 1 data %x", data)
+	}
+}
+
+func TestFrameAssemblerReorder(t *testing.T) {
+	assembler := newTestAssembler(t, vp8Codec)
+	stream := vp8Stream(5, 0)
+	var packets []*rtp.Packet
+	for _, frame := range stream {
+		packets = append(packets, frame...)
+	}
+	// Swap packets within a frame and across frames
+	packets[2], packets[3] = packets[3], packets[2]
+	packets[5], packets[6] = packets[6], packets[5]
+
+	frames, lost := pushAll(t, assembler, packets)
+	if want := ptsOf(0, 1, 2, 3); !equalPTS(framePTS(frames), want) {
+		t.Fatalf("frames at %v, want %v", framePTS(frames), want)
+	}
+	if lost || assembler.LostPackets() != 0 {
+		t.Errorf("reported %d lost packets for reordered ones", assembler.LostPackets())
+	}
+}
+
+func TestFrameAssemblerStartsOnKeyframe(t *testing.T) {
+	assembler := newTestAssembler(t, vp8Codec)
+	var packets []*rtp.Packet
+	for _, frame := range vp8Stream(6, 3) {
+		packets = append(packets, frame...)
+	}
+
+	frames, _ := pushAll(t, assembler, packets)
+	if want := ptsOf(3, 4); !equalPTS(framePTS(frames), want) {
+		t.Fatalf("frames at %v, want %v", framePTS(frames), want)
+	}
+}
+
+func TestFrameAssemblerLoss(t *testing.T) {
+	assembler := newTestAssembler(t, vp8Codec)
+	stream := vp8Stream(30, 0, 20)
+	var packets []*rtp.Packet
+	for i, frame := range stream {
+		if i == 5 {
+			// Frame 5 loses its second packet
+			frame = frame[:1]
+		}
+		packets = append(packets, frame...)
+	}
+
+	frames, lost := pushAll(t, assembler, packets)
+	if !lost || assembler.LostPackets() != 1 {
+		t.Fatalf("lost %d packets, want 1", assembler.LostPackets())
+	}
+	// Frames after the loss reference missing data until the keyframe
+	want := append(ptsOf(0, 1, 2, 3, 4), ptsOf(20, 21, 22, 23, 24, 25, 26, 27, 28)...)
+	if !equalPTS(framePTS(frames), want) {
+		t.Fatalf("frames at %v, want %v", framePTS(frames), want)
+	}
+}
+
+func TestFrameAssemblerAudioLoss(t *testing.T) {
+	assembler := newTestAssembler(t, opusCodec)
+	var frames []connectivity.Frame
+	lost := false
+	for i := 0; i < 40; i++ {
+		if i == 10 {
+			continue
+		}
+		pushed, pushLost := assembler.Push(&rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				SequenceNumber: uint16(60000 + i), // Wraps around
+				Timestamp:      uint32(i * 960),
+			},
+			Payload: []byte{0xfc, byte(i)},
+		})
+		frames = append(frames, pushed...)
+		lost = lost || pushLost
+	}
+
+	if !lost || assembler.LostPackets() != 1 {
+		t.Errorf("lost %d packets, want 1", assembler.LostPackets())
+	}
+	// Audio is never held back waiting for a keyframe
+	if len(frames) < 30 {
+		t.Fatalf("released %d audio frames", len(frames))
+	}
+	for _, frame := range frames {
+		if !frame.Keyframe || frame.Codec != connectivity.CodecOpus {
+			t.Fatalf("audio frame keyframe %v codec %s", frame.Keyframe, frame.Codec)
+		}
+		if frame.Data[1] == 10 {
+			t.Fatalf("released the lost packet")
+		}
+	}
+}
@@ -2,9 +2,9 @@ package receivers
 
 import (
 	"context"
-	"encoding/binary"
 	"fmt"
 	"io"
+	"katkam/internal/config"
 	"katkam/internal/infrastructure/connectivity"
 	"net/http"
 	"os/exec"
@@ -15,15 +15,34 @@ import (
 type Camera struct {
 	connectivity.VideoStreamer
 
-	Device      string
+	Backend     CaptureBackend
+	Config      config.Camera
 	StreamCmd   *exec.Cmd
 	StreamMutex sync.Mutex
 	IsStreaming bool
+
+	// audio captures the microphone next to the video when enabled
+	audio *ffmpegProcess
 }
 
-func NewCamera() *Camera {
+func NewCamera(cfg config.Camera) *Camera {
+	backend := DefaultCaptureBackend()
+	if cfg.Backend != "" {
+		backend.VideoFormat = cfg.Backend
+	}
+	if cfg.Device != "" {
+		backend.VideoDevice = cfg.Device
+	}
+	if cfg.AudioBackend != "" {
+		backend.AudioFormat = cfg.AudioBackend
+	}
+	if cfg.AudioDevice != "" {
+		backend.AudioDevice = cfg.AudioDevice
+	}
+
 	return &Camera{
-		Device: "0", // Default camera input for AVFoundation on macOS
+		Backend: backend,
+		Config:  cfg,
 	}
 }
 
@@ -37,11 +56,11 @@ func (c *Camera) Start() error {
 	return nil
 }
 
-func (r *Camera) AssignVideoFrameCallback(fn func([]byte)) {
+func (r *Camera) AssignVideoFrameCallback(fn func(connectivity.Frame)) {
 	r.OnVideoFrame = fn
 }
 
-func (r *Camera) AssignAudioFrameCallback(fn func([]byte)) {
+func (r *Camera) AssignAudioFrameCallback(fn func(connectivity.Frame)) {
 	r.OnAudioFrame = fn
 }
 
@@ -56,125 +75,237 @@ func (r *Camera) AssignDisconnectedCallback(fn func()) {
 func (c *Camera) StartVideoCapture(filename string, duration time.Duration) error {
 	fmt.Printf("🎬 Starting video capture for %.0f seconds...\n", duration.Seconds())
 	c.StreamMutex.Lock()
-	defer c.StreamMutex.Unlock()
 
 	if c.IsStreaming {
+		c.StreamMutex.Unlock()
 		return fmt.Errorf("camera is already streaming")
 	}
 
-	// Create context for cancellation
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// Use ffmpeg to capture video and output IVF format for VP8 frames
-	// Note: macOS requires camera permission for Terminal/process
-	cmd := exec.CommandContext(ctx, "ffmpeg",
-		"-f", "avfoundation",
-		"-video_size", "640x480", // Resolution supported by camera
-		"-framerate", "30", // Use 30fps as it's better supported
-		"-i", c.Device, // Camera device
-		"-t", fmt.Sprintf("%.0f", duration.Seconds()),
-		"-c:v", "libvpx",
-		"-b:v", "500k", // Lower bitrate
-		"-crf", "40", // Higher CRF for smaller files
-		"-f", "ivf", // IVF format contains individual VP8 frames
-		"-", // Output to stdout for streaming
-	)
-
-	// Set up pipes for streaming
-	stdout, err := cmd.StdoutPipe()
+	videoSize := fmt.Sprintf("%dx%d", c.Config.Width, c.Config.Height)
+	inputFormat, err := c.chooseInputFormat(videoSize)
 	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %v", err)
+		c.StreamMutex.Unlock()
+		return err
 	}
-
-	// Also capture stderr for debugging
-	stderr, err := cmd.StderrPipe()
+	inputArgs, err := c.Backend.videoInputArgs(videoSize, c.Config.Framerate, inputFormat)
 	if err != nil {
-		return fmt.Errorf("failed to create stderr pipe: %v", err)
+		c.StreamMutex.Unlock()
+		return err
 	}
 
-	// Start the command
-	fmt.Printf("📹 Starting FFmpeg command: %s\n", cmd.String())
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start ffmpeg: %v", err)
+	// Use ffmpeg to capture video and output IVF format for VP8 frames, or
+	// an Annex-B byte stream for H.264 which IVF cannot carry from ffmpeg
+	// Note: macOS requires camera permission for Terminal/process, on Linux
+	// the user needs access to the video device (usually the video group)
+	args := append(inputArgs, "-t", fmt.Sprintf("%.0f", duration.Seconds()))
+	parse := c.captureFramesToCallback
+	switch {
+	case inputFormat == formatH264:
+		// The camera's H.264 is relayed without decoding it, viewers joining
+		// wait for its next keyframe
+		if err := c.Backend.setKeyframeInterval(c.Config.KeyframeInterval); err != nil {
+			fmt.Printf("⚠️ Cannot set the camera's keyframe interval, viewers may wait long for its keyframes: %v\n", err)
+		} else {
+			fmt.Printf("📷 Camera sends a keyframe every %d frames\n", c.Config.KeyframeInterval)
+		}
+		args = append(args, "-c:v", "copy", "-bsf:v", "dump_extra=freq=keyframe", "-f", "h264")
+		parse = c.captureH264ToCallback
+	case c.Config.Codec == config.CodecH264:
+		args = append(args, c.encoderArgs()...)
+		args = append(args, "-f", "h264")
+		parse = c.captureH264ToCallback
+	default:
+		args = append(args, c.encoderArgs()...)
+		args = append(args, "-f", "ivf") // IVF format contains individual VP8 frames
 	}
-	fmt.Println("✅ FFmpeg started successfully")
+	args = append(args, "-") // Output to stdout for streaming
 
-	// Log stderr in background
-	go func() {
-		buffer := make([]byte, 1024)
-		for {
-			n, err := stderr.Read(buffer)
-			if err != nil {
-				break
-			}
-			if n > 0 {
-				fmt.Printf("FFmpeg stderr: %s", string(buffer[:n]))
-			}
-		}
-	}()
+	process, err := startFFmpeg("camera", args, parse)
+	if err != nil {
+		c.StreamMutex.Unlock()
+		return err
+	}
 
-	c.StreamCmd = cmd
+	c.StreamCmd = process.cmd
 	c.IsStreaming = true
-
-	// Stream frames to callback in fire-and-forget manner
-	go c.captureFramesToCallback(stdout, ctx)
+	if c.Config.Audio {
+		c.audio = c.startAudioCapture(duration)
+	}
+	c.StreamMutex.Unlock()
 
 	// Wait for command to complete
-	err = cmd.Wait()
+	err = process.Wait()
 
 	c.StreamMutex.Lock()
 	c.IsStreaming = false
+	c.stopAudioCapture()
 	c.StreamMutex.Unlock()
 
 	return err
 }
 
-func (c *Camera) captureFramesToCallback(reader io.Reader, ctx context.Context) {
-	// Skip IVF header (32 bytes)
-	header := make([]byte, 32)
-	_, err := io.ReadFull(reader, header)
-	if err != nil {
-		fmt.Printf("Failed to read IVF header: %v\n", err)
-		return
-	}
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			// Read IVF frame header (12 bytes)
-			frameHeader := make([]byte, 12)
-			_, err := io.ReadFull(reader, frameHeader)
-			if err != nil {
-				if err != io.EOF {
-					fmt.Printf("Camera stream ended: %v\n", err)
-				}
-				return
-			}
+// chooseInputFormat picks the format to read from the device according to
+// the capture mode: h264 to pass the camera's own encoding through, mjpeg to
+// decode cheap JPEGs instead of moving raw frames over USB, or empty for raw
+// frames. Auto probes the device and falls back to raw frames.
+func (c *Camera) chooseInputFormat(videoSize string) (string, error) {
+	mode := c.Config.CaptureMode
+	if mode == "" || mode == config.CaptureEncode {
+		return "", nil
+	}
 
-			// Extract frame size from header (little-endian uint32 at offset 0)
-			frameSize := binary.LittleEndian.Uint32(frameHeader[0:4])
-			if frameSize == 0 || frameSize > 1024*1024 { // Sanity check
-				fmt.Printf("Invalid frame size: %d\n", frameSize)
-				continue
+	formats, err := c.Backend.probeFormats()
+	if mode == config.CaptureAuto {
+		if err != nil {
+			fmt.Printf("⚠️ Cannot probe camera formats, encoding raw frames: %v\n", err)
+			return "", nil
+		}
+		for _, name := range []string{formatH264, formatMJPEG} {
+			if _, ok := findFormat(formats, name, videoSize); ok {
+				fmt.Printf("📷 Camera offers %s at %s, capturing it\n", name, videoSize)
+				return name, nil
 			}
+		}
+		fmt.Printf("📷 Camera offers no compressed format at %s, encoding raw frames\n", videoSize)
+		return "", nil
+	}
 
-			// Read the actual VP8 frame data
-			frameData := make([]byte, frameSize)
-			_, err = io.ReadFull(reader, frameData)
-			if err != nil {
-				fmt.Printf("Failed to read frame data: %v\n", err)
-				return
-			}
+	name := formatMJPEG
+	if mode == config.CapturePassthrough {
+		name = formatH264
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot capture %s: %v", name, err)
+	}
+	if _, ok := findFormat(formats, name, videoSize); !ok {
+		return "", fmt.Errorf("camera %s does not offer %s at %s", c.Backend.VideoDevice, name, videoSize)
+	}
+	fmt.Printf("📷 Capturing %s at %s from the camera\n", name, videoSize)
+	return name, nil
+}
 
-			// Send the VP8 frame to WebRTC
-			if c.OnVideoFrame != nil {
-				c.OnVideoFrame(frameData)
-			}
+// startAudioCapture runs a second ffmpeg encoding the microphone to Opus.
+// Both processes start together, so the timestamps of each, counting from
+// zero, line up closely enough. The video keeps running without audio when
+// the microphone cannot be opened.
+func (c *Camera) startAudioCapture(duration time.Duration) *ffmpegProcess {
+	inputArgs, err := c.Backend.audioInputArgs()
+	if err != nil {
+		fmt.Printf("⚠️ Camera audio disabled: %v\n", err)
+		return nil
+	}
+
+	args := append(inputArgs, "-t", fmt.Sprintf("%.0f", duration.Seconds()))
+	args = append(args,
+		"-vn",
+		"-c:a", "libopus",
+		"-ar", "48000",
+		"-b:a", c.Config.AudioBitrate,
+		"-application", "audio",
+		"-frame_duration", "20",
+		"-page_duration", "20000", // One 20ms Opus packet per Ogg page
+		"-f", "ogg",
+		"-",
+	)
+
+	process, err := startFFmpeg("camera audio", args, c.captureAudioToCallback)
+	if err != nil {
+		fmt.Printf("⚠️ Camera audio disabled: %v\n", err)
+		return nil
+	}
+
+	go func() {
+		if err := process.Wait(); err != nil {
+			fmt.Printf("⚠️ Camera audio capture stopped: %v\n", err)
 		}
+	}()
+	return process
+}
+
+// stopAudioCapture stops the microphone capture, must be called with
+// StreamMutex held.
+func (c *Camera) stopAudioCapture() {
+	if c.audio != nil {
+		c.audio.Stop()
+		c.audio = nil
+	}
+}
+
+func (c *Camera) encoderArgs() []string {
+	if c.Config.Codec == config.CodecH264 {
+		return c.h264EncoderArgs()
+	}
+
+	encoder := c.Config.Encoder
+	if encoder == "" {
+		encoder = "libvpx"
+	}
+	args := []string{
+		"-c:v", encoder,
+		"-g", fmt.Sprintf("%d", c.Config.KeyframeInterval),
+		"-b:v", c.Config.Bitrate,
+		"-crf", fmt.Sprintf("%d", c.Config.CRF),
 	}
+	if c.Config.Deadline != "" {
+		args = append(args, "-deadline", c.Config.Deadline)
+	}
+	if c.Config.CpuUsed != 0 {
+		args = append(args, "-cpu-used", fmt.Sprintf("%d", c.Config.CpuUsed))
+	}
+	return append(args, c.Config.ExtraArgs...)
+}
+
+// h264EncoderArgs encodes H.264 for real time, with libx264 unless a
+// hardware encoder is configured.
+func (c *Camera) h264EncoderArgs() []string {
+	encoder := c.Config.Encoder
+	if encoder == "" {
+		encoder = "libx264"
+	}
+	args := []string{
+		"-c:v", encoder,
+		"-g", fmt.Sprintf("%d", c.Config.KeyframeInterval),
+		"-b:v", c.Config.Bitrate,
+		"-pix_fmt", "yuv420p",
+	}
+	if encoder == "libx264" {
+		// Constrained baseline without B-frames decodes everywhere and
+		// keeps frames in presentation order
+		args = append(args,
+			"-preset", "ultrafast",
+			"-tune", "zerolatency",
+			"-profile:v", "baseline",
+		)
+	}
+	// Repeat the parameter sets in front of every keyframe, viewers joining
+	// later cannot decode without them
+	args = append(args, "-bsf:v", "dump_extra=freq=keyframe")
+	return append(args, c.Config.ExtraArgs...)
+}
+
+func (c *Camera) captureFramesToCallback(ctx context.Context, reader io.Reader) {
+	readIVFFrames(ctx, reader, func(frame connectivity.Frame) {
+		// Send the VP8 frame to WebRTC
+		if c.OnVideoFrame != nil {
+			c.OnVideoFrame(frame)
+		}
+	})
+}
+
+func (c *Camera) captureH264ToCallback(ctx context.Context, reader io.Reader) {
+	readAnnexBFrames(ctx, reader, time.Second/time.Duration(c.Config.Framerate), func(frame connectivity.Frame) {
+		if c.OnVideoFrame != nil {
+			c.OnVideoFrame(frame)
+		}
+	})
+}
+
+func (c *Camera) captureAudioToCallback(ctx context.Context, reader io.Reader) {
+	readOggOpusPackets(ctx, reader, func(frame connectivity.Frame) {
+		if c.OnAudioFrame != nil {
+			c.OnAudioFrame(frame)
+		}
+	})
 }
 
 func (c *Camera) StopVideoCapture() error {
@@ -185,6 +316,7 @@ func (c *Camera) StopVideoCapture() error {
 		return fmt.Errorf("camera is not currently streaming")
 	}
 
+	c.stopAudioCapture()
 	if c.StreamCmd != nil && c.StreamCmd.Process != nil {
 		if err := c.StreamCmd.Process.Kill(); err != nil {
 			return fmt.Errorf("failed to kill capture process: %v", err)
@@ -206,6 +338,11 @@ func (c *Camera) HandleWebSocketConnection(w http.ResponseWriter, req *http.Requ
 	panic("Camera is directly connected, it should not handle websocket connection. Make sure you configured the receiver correctly.")
 }
 
+// RequestKeyframe is a no-op, the encoder cannot be asked for a keyframe
+// while ffmpeg runs. The keyframe interval bounds how long viewers wait
+// instead, set on the camera itself when its H.264 is passed through.
+func (c *Camera) RequestKeyframe() {}
+
 func (c *Camera) IsConnected() bool {
 	return c.IsStreaming
 }
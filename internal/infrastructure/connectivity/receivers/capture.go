@@ -0,0 +1,47 @@
+package receivers
+
+import "fmt"
+
+// CaptureBackend describes the ffmpeg input devices used by the Camera to
+// grab video and audio from the host.
+type CaptureBackend struct {
+	VideoFormat string // ffmpeg input format, e.g. avfoundation or v4l2
+	VideoDevice string
+	AudioFormat string // ffmpeg input format, e.g. avfoundation, alsa or pulse
+	AudioDevice string
+}
+
+// DefaultCaptureBackend returns the capture backend for the platform katkam
+// was built for.
+func DefaultCaptureBackend() CaptureBackend {
+	return defaultCaptureBackend()
+}
+
+// videoInputArgs opens the video device, in the device's compressed
+// inputFormat such as h264 or mjpeg, or raw frames when it is empty.
+func (b CaptureBackend) videoInputArgs(videoSize string, framerate int, inputFormat string) ([]string, error) {
+	if b.VideoFormat == "" {
+		return nil, fmt.Errorf("no video capture backend available on this platform")
+	}
+
+	args := []string{
+		"-f", b.VideoFormat,
+		"-video_size", videoSize,
+		"-framerate", fmt.Sprintf("%d", framerate),
+	}
+	if inputFormat != "" {
+		args = append(args, "-input_format", inputFormat)
+	}
+	return append(args, "-i", b.VideoDevice), nil
+}
+
+func (b CaptureBackend) audioInputArgs() ([]string, error) {
+	if b.AudioFormat == "" {
+		return nil, fmt.Errorf("no audio capture backend available on this platform")
+	}
+
+	return []string{
+		"-f", b.AudioFormat,
+		"-i", b.AudioDevice,
+	}, nil
+}
@@ -0,0 +1,12 @@
+//go:build darwin
+
+package receivers
+
+func defaultCaptureBackend() CaptureBackend {
+	return CaptureBackend{
+		VideoFormat: "avfoundation",
+		VideoDevice: "0", // Default camera input for AVFoundation on macOS
+		AudioFormat: "avfoundation",
+		AudioDevice: ":0", // Default microphone input for AVFoundation on macOS
+	}
+}
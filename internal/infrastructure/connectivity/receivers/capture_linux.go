@@ -0,0 +1,22 @@
+//go:build linux
+
+package receivers
+
+import "os"
+
+func defaultCaptureBackend() CaptureBackend {
+	backend := CaptureBackend{
+		VideoFormat: "v4l2",
+		VideoDevice: "/dev/video0",
+		AudioFormat: "alsa",
+		AudioDevice: "default",
+	}
+
+	// Prefer PulseAudio (or PipeWire's pulse shim) when a server is reachable,
+	// so the microphone can be shared with other applications.
+	if os.Getenv("PULSE_SERVER") != "" {
+		backend.AudioFormat = "pulse"
+	}
+
+	return backend
+}
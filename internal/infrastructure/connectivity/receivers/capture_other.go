@@ -0,0 +1,7 @@
+//go:build !darwin && !linux
+
+package receivers
+
+func defaultCaptureBackend() CaptureBackend {
+	return CaptureBackend{}
+}
@@ -0,0 +1,272 @@
+package receivers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"katkam/internal/infrastructure/connectivity"
+	"katkam/internal/infrastructure/media/h264"
+	"katkam/internal/infrastructure/media/ivf"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3/pkg/media/oggreader"
+)
+
+// ffmpegProcess is a running ffmpeg command whose outputs are consumed by
+// parsers until the process exits or is stopped.
+type ffmpegProcess struct {
+	cmd    *exec.Cmd
+	cancel context.CancelFunc
+	parsed chan struct{}
+}
+
+// startFFmpeg runs ffmpeg, handing its stdout to parse. Each of the extra
+// parsers reads one more output, pipe:3 and onwards in ffmpeg's arguments.
+func startFFmpeg(name string, args []string, parse func(ctx context.Context, reader io.Reader), extra ...func(ctx context.Context, reader io.Reader)) (*ffmpegProcess, error) {
+	// Create context for cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	var extraReaders []*os.File
+	closeExtra := func() {
+		for _, file := range extraReaders {
+			file.Close()
+		}
+		for _, file := range cmd.ExtraFiles {
+			file.Close()
+		}
+	}
+	for range extra {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			closeExtra()
+			cancel()
+			return nil, fmt.Errorf("failed to create output pipe: %v", err)
+		}
+		extraReaders = append(extraReaders, reader)
+		cmd.ExtraFiles = append(cmd.ExtraFiles, writer)
+	}
+
+	// Set up pipes for streaming
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create stdout pipe: %v", err)
+	}
+
+	// Also capture stderr for debugging
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create stderr pipe: %v", err)
+	}
+
+	// Start the command
+	fmt.Printf("📹 Starting %s FFmpeg command: %s\n", name, cmd.String())
+	if err := cmd.Start(); err != nil {
+		closeExtra()
+		cancel()
+		return nil, fmt.Errorf("failed to start ffmpeg: %v", err)
+	}
+	fmt.Printf("✅ %s FFmpeg started successfully\n", name)
+	// Only ffmpeg writes to the extra outputs, they end when it exits
+	for _, file := range cmd.ExtraFiles {
+		file.Close()
+	}
+
+	// Log stderr in background
+	go func() {
+		buffer := make([]byte, 1024)
+		for {
+			n, err := stderr.Read(buffer)
+			if err != nil {
+				break
+			}
+			if n > 0 {
+				fmt.Printf("FFmpeg %s stderr: %s", name, string(buffer[:n]))
+			}
+		}
+	}()
+
+	p := &ffmpegProcess{
+		cmd:    cmd,
+		cancel: cancel,
+		parsed: make(chan struct{}),
+	}
+
+	// Stream the outputs to their parsers, each is drained to the end so a
+	// parser giving up early never blocks ffmpeg's other outputs
+	var parsers sync.WaitGroup
+	parsers.Add(1 + len(extra))
+	go func() {
+		defer parsers.Done()
+		parse(ctx, stdout)
+		io.Copy(io.Discard, stdout)
+	}()
+	for i, parseExtra := range extra {
+		go func(reader *os.File) {
+			defer parsers.Done()
+			defer reader.Close()
+			parseExtra(ctx, reader)
+			io.Copy(io.Discard, reader)
+		}(extraReaders[i])
+	}
+	go func() {
+		parsers.Wait()
+		close(p.parsed)
+	}()
+
+	return p, nil
+}
+
+// Wait blocks until the parsers have drained the outputs and the process
+// exited.
+func (p *ffmpegProcess) Wait() error {
+	<-p.parsed
+	err := p.cmd.Wait()
+	p.cancel()
+	return err
+}
+
+func (p *ffmpegProcess) Stop() {
+	p.cancel()
+}
+
+// readIVFFrames parses an IVF stream of VP8 or H.264 frames, going by the
+// codec in its header, and hands every frame to onFrame.
+func readIVFFrames(ctx context.Context, reader io.Reader, onFrame func(connectivity.Frame)) {
+	ivfReader, err := ivf.NewReader(reader)
+	if err != nil {
+		fmt.Printf("Failed to read IVF header: %v\n", err)
+		return
+	}
+	fourCC := ivfReader.Header().FourCC
+	codec := ivf.MimeType(fourCC)
+	if codec != connectivity.CodecVP8 && codec != connectivity.CodecH264 {
+		fmt.Printf("❌ Unsupported IVF codec %q, only VP80 and H264 can be relayed\n", fourCC)
+		return
+	}
+
+	var skipped int64
+	var previous time.Duration
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			frame, err := ivfReader.ReadFrame()
+			if err != nil {
+				if err != io.EOF {
+					fmt.Printf("Video stream ended: %v\n", err)
+				}
+				return
+			}
+
+			if ivfReader.Skipped() != skipped {
+				fmt.Printf("⚠️ IVF stream resynchronised after skipping %d corrupted bytes\n", ivfReader.Skipped()-skipped)
+				skipped = ivfReader.Skipped()
+			}
+
+			onFrame(connectivity.Frame{
+				Data:     frame.Payload,
+				PTS:      frame.PTS,
+				Duration: frame.PTS - previous,
+				Keyframe: connectivity.IsKeyframe(codec, frame.Payload),
+				Codec:    codec,
+			})
+			previous = frame.PTS
+		}
+	}
+}
+
+// readAnnexBFrames parses an H.264 byte stream and hands every access unit
+// to onFrame. The stream carries no timestamps, so frames are stamped with
+// the time they arrived rather than counted at the nominal interval, a camera
+// delivering fewer frames than requested then stays in step with the audio.
+func readAnnexBFrames(ctx context.Context, reader io.Reader, interval time.Duration, onFrame func(connectivity.Frame)) {
+	h264Reader := h264.NewReader(reader)
+
+	var start time.Time
+	var previous time.Duration
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			accessUnit, err := h264Reader.ReadAccessUnit()
+			if err != nil {
+				if err != io.EOF {
+					fmt.Printf("Video stream ended: %v\n", err)
+				}
+				return
+			}
+
+			pts, duration := time.Duration(0), interval
+			if start.IsZero() {
+				start = time.Now()
+			} else {
+				pts = max(time.Since(start), previous+time.Millisecond)
+				duration = pts - previous
+			}
+
+			onFrame(connectivity.Frame{
+				Data:     accessUnit,
+				PTS:      pts,
+				Duration: duration,
+				Keyframe: h264.IsKeyframe(accessUnit),
+				Codec:    connectivity.CodecH264,
+			})
+			previous = pts
+		}
+	}
+}
+
+// readOggOpusPackets parses an Ogg/Opus stream and hands every packet to
+// onFrame. ffmpeg must be run with -page_duration matching the Opus frame
+// duration so that each page carries exactly one packet.
+func readOggOpusPackets(ctx context.Context, reader io.Reader, onFrame func(connectivity.Frame)) {
+	ogg, _, err := oggreader.NewWith(reader)
+	if err != nil {
+		fmt.Printf("Failed to read Ogg header: %v\n", err)
+		return
+	}
+
+	// Granule positions count 48kHz samples at the end of each page
+	var previousGranule uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			payload, pageHeader, err := ogg.ParseNextPage()
+			if err != nil {
+				if err != io.EOF {
+					fmt.Printf("Audio stream ended: %v\n", err)
+				}
+				return
+			}
+
+			// The comment header is not audio
+			if bytes.HasPrefix(payload, []byte("OpusTags")) {
+				continue
+			}
+
+			granule := pageHeader.GranulePosition
+			if granule < previousGranule {
+				previousGranule = granule
+			}
+			onFrame(connectivity.Frame{
+				Data:     payload,
+				PTS:      connectivity.OpusDuration(previousGranule),
+				Duration: connectivity.OpusDuration(granule - previousGranule),
+				Keyframe: true,
+				Codec:    connectivity.CodecOpus,
+			})
+			previousGranule = granule
+		}
+	}
+}
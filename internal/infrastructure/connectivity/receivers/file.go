@@ -0,0 +1,322 @@
+package receivers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"katkam/internal/config"
+	"katkam/internal/infrastructure/connectivity"
+	"katkam/internal/infrastructure/media/ivf"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// playbackFrameInterval is the duration of the first H.264 frame of a
+// remuxed recording, the following ones are timed by their arrival
+const playbackFrameInterval = time.Second / 30
+
+// FilePlayback is a receiver replaying a recording from disk at the pace of
+// its recorded timestamps. IVF files are parsed directly, WebM, Matroska and
+// MP4 files are remuxed by ffmpeg, re-encoding only audio other than Opus.
+type FilePlayback struct {
+	connectivity.VideoStreamer
+
+	Config config.Playback
+
+	cancel      context.CancelFunc
+	isStreaming bool
+	mutex       sync.RWMutex
+}
+
+func NewFilePlayback(cfg config.Playback) *FilePlayback {
+	return &FilePlayback{
+		Config: cfg,
+	}
+}
+
+func (f *FilePlayback) Start() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.isStreaming {
+		return fmt.Errorf("file playback is already running")
+	}
+
+	if _, err := os.Stat(f.Config.Path); err != nil {
+		return fmt.Errorf("failed to open recording: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel = cancel
+	f.isStreaming = true
+	if f.OnConnected != nil {
+		go f.OnConnected()
+	}
+
+	go func() {
+		var err error
+		switch strings.ToLower(filepath.Ext(f.Config.Path)) {
+		case ".ivf":
+			err = f.playIVF(ctx)
+		default:
+			err = f.playRemuxed(ctx)
+		}
+		if err != nil {
+			fmt.Printf("❌ File playback stopped: %v\n", err)
+		} else {
+			fmt.Println("File playback finished")
+		}
+
+		cancel()
+		f.mutex.Lock()
+		f.isStreaming = false
+		f.mutex.Unlock()
+		if f.OnDisconnected != nil {
+			go f.OnDisconnected()
+		}
+	}()
+
+	return nil
+}
+
+func (f *FilePlayback) playIVF(ctx context.Context) error {
+	start := time.Now()
+	var offset, last time.Duration
+
+	for {
+		file, err := os.Open(f.Config.Path)
+		if err != nil {
+			return fmt.Errorf("failed to open recording: %v", err)
+		}
+
+		last, err = f.streamIVF(ctx, file, start, offset)
+		file.Close()
+		if err != nil {
+			return err
+		}
+
+		if !f.Config.Loop || ctx.Err() != nil {
+			return nil
+		}
+
+		// Continue the timeline where the previous pass ended
+		offset = last
+	}
+}
+
+// streamIVF paces the frames of a single pass through the file and returns
+// the timeline position at which the pass ended.
+func (f *FilePlayback) streamIVF(ctx context.Context, file io.Reader, start time.Time, offset time.Duration) (time.Duration, error) {
+	reader, err := ivf.NewReader(file)
+	if err != nil {
+		return offset, fmt.Errorf("failed to read IVF header: %v", err)
+	}
+	header := reader.Header()
+	codec := ivf.MimeType(header.FourCC)
+	if codec != connectivity.CodecVP8 && codec != connectivity.CodecH264 {
+		return offset, fmt.Errorf("unsupported IVF codec %q, only VP80 and H264 can be relayed", header.FourCC)
+	}
+
+	position, previous, interval := offset, offset, header.PTS(1)
+	for {
+		frame, err := reader.ReadFrame()
+		if err == io.EOF {
+			return position, nil
+		}
+		if err != nil {
+			return position, fmt.Errorf("failed to read IVF frame: %v", err)
+		}
+
+		pts := offset + frame.PTS
+		if pts > previous {
+			interval = pts - previous
+		}
+		previous = pts
+		select {
+		case <-ctx.Done():
+			return pts, nil
+		case <-time.After(time.Until(start.Add(pts))):
+		}
+
+		if f.OnVideoFrame != nil {
+			f.OnVideoFrame(connectivity.Frame{
+				Data:     frame.Payload,
+				PTS:      pts,
+				Duration: interval,
+				Keyframe: connectivity.IsKeyframe(codec, frame.Payload),
+				Codec:    codec,
+			})
+		}
+		position = pts + interval
+	}
+}
+
+// playRemuxed demuxes video and audio with a single ffmpeg, so both follow
+// the same clock when paced with -re: VP8 remuxed to IVF or H.264 to Annex-B
+// on stdout, which ffmpeg cannot write to IVF, and audio as Ogg/Opus on
+// pipe:3. Opus is copied, other audio codecs are transcoded.
+func (f *FilePlayback) playRemuxed(ctx context.Context) error {
+	videoCodec, err := probeCodec(f.Config.Path, "v:0")
+	if err != nil {
+		return err
+	}
+
+	args := []string{"-re"}
+	if f.Config.Loop {
+		args = append(args, "-stream_loop", "-1")
+	}
+	args = append(args,
+		"-i", f.Config.Path,
+		"-map", "0:v:0",
+		"-c:v", "copy",
+	)
+	parseVideo := f.captureVideoToCallback
+	switch videoCodec {
+	case "vp8":
+		args = append(args, "-f", "ivf", "pipe:1")
+	case "h264":
+		// MP4 and Matroska store H.264 length prefixed, the relay sends
+		// Annex-B
+		args = append(args, "-bsf:v", "h264_mp4toannexb", "-f", "h264", "pipe:1")
+		parseVideo = f.captureH264ToCallback
+	case "":
+		return fmt.Errorf("recording %s has no video", f.Config.Path)
+	default:
+		return fmt.Errorf("unsupported video codec %s, only VP8 and H.264 recordings can be replayed", videoCodec)
+	}
+
+	var extra []func(context.Context, io.Reader)
+	audioCodec, err := probeCodec(f.Config.Path, "a:0")
+	switch {
+	case err != nil:
+		fmt.Printf("⚠️ Playing the recording without audio: %v\n", err)
+	case audioCodec == "":
+		// Recording without audio
+	default:
+		args = append(args, "-map", "0:a:0")
+		if audioCodec == "opus" {
+			args = append(args, "-c:a", "copy")
+		} else {
+			fmt.Printf("Transcoding %s audio of the recording to Opus\n", audioCodec)
+			args = append(args,
+				"-c:a", "libopus",
+				"-ar", "48000",
+				"-b:a", "64k",
+				"-frame_duration", "20",
+			)
+		}
+		args = append(args,
+			"-page_duration", "20000",
+			"-f", "ogg",
+			"pipe:3",
+		)
+		extra = append(extra, f.captureAudioToCallback)
+	}
+
+	process, err := startFFmpeg("playback", args, parseVideo, extra...)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		process.Stop()
+	}()
+
+	err = process.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// probeCodec returns the codec of a stream of a recording, e.g. a:0 for the
+// first audio stream, or an empty string when there is no such stream.
+func probeCodec(path, stream string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-select_streams", stream,
+		"-show_entries", "stream=codec_name",
+		"-of", "csv=p=0",
+		path,
+	).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to probe codec of stream %s: %v", stream, err)
+	}
+	codec, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	return strings.TrimSpace(codec), nil
+}
+
+func (f *FilePlayback) captureVideoToCallback(ctx context.Context, reader io.Reader) {
+	readIVFFrames(ctx, reader, func(frame connectivity.Frame) {
+		if f.OnVideoFrame != nil {
+			f.OnVideoFrame(frame)
+		}
+	})
+}
+
+// captureH264ToCallback stamps the access units as they arrive, ffmpeg paces
+// them with -re.
+func (f *FilePlayback) captureH264ToCallback(ctx context.Context, reader io.Reader) {
+	readAnnexBFrames(ctx, reader, playbackFrameInterval, func(frame connectivity.Frame) {
+		if f.OnVideoFrame != nil {
+			f.OnVideoFrame(frame)
+		}
+	})
+}
+
+func (f *FilePlayback) captureAudioToCallback(ctx context.Context, reader io.Reader) {
+	readOggOpusPackets(ctx, reader, func(frame connectivity.Frame) {
+		if f.OnAudioFrame != nil {
+			f.OnAudioFrame(frame)
+		}
+	})
+}
+
+func (f *FilePlayback) AssignVideoFrameCallback(fn func(connectivity.Frame)) {
+	f.OnVideoFrame = fn
+}
+
+func (f *FilePlayback) AssignAudioFrameCallback(fn func(connectivity.Frame)) {
+	f.OnAudioFrame = fn
+}
+
+func (f *FilePlayback) AssignConnectedCallback(fn func()) {
+	f.OnConnected = fn
+}
+
+func (f *FilePlayback) AssignDisconnectedCallback(fn func()) {
+	f.OnDisconnected = fn
+}
+
+func (f *FilePlayback) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.cancel != nil {
+		f.cancel()
+	}
+	return nil
+}
+
+func (f *FilePlayback) HandleWebSocketConnection(w http.ResponseWriter, req *http.Request) {
+	http.Error(w, "File playback receiver does not accept publishers", http.StatusConflict)
+}
+
+// RequestKeyframe is a no-op, recordings can only be replayed as they were
+// encoded.
+func (f *FilePlayback) RequestKeyframe() {}
+
+func (f *FilePlayback) IsConnected() bool {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.isStreaming
+}
@@ -0,0 +1,141 @@
+package receivers
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const probeTimeout = 10 * time.Second
+
+// keyframeControls are the V4L2 controls setting the distance between H.264
+// keyframes, older drivers call it the I-frame period
+var keyframeControls = []string{"h264_i_frame_period", "video_gop_size"}
+
+// Compressed formats of USB cameras, named as ffmpeg's -input_format
+// expects them
+const (
+	formatH264  = "h264"
+	formatMJPEG = "mjpeg"
+)
+
+// captureFormat is a pixel or compressed format a capture device offers.
+type captureFormat struct {
+	Name  string   // e.g. yuyv422, mjpeg or h264
+	Sizes []string // e.g. 640x480, or a {min-max, step} range
+}
+
+// supports reports whether the format can be captured at videoSize.
+func (f captureFormat) supports(videoSize string) bool {
+	for _, size := range f.Sizes {
+		// Stepwise sizes are ranges, the driver picks the nearest one
+		if size == videoSize || strings.HasPrefix(size, "{") {
+			return true
+		}
+	}
+	return false
+}
+
+// formatLine matches the lines of ffmpeg's v4l2 -list_formats output, e.g.
+// "[video4linux2,v4l2 @ 0x5581] Compressed:       mjpeg :          Motion-JPEG : 640x480 1280x720"
+var formatLine = regexp.MustCompile(`\]\s*(?:Raw|Compressed)\s*:\s*(.*)$`)
+
+// fieldSeparator splits the fields of a format line, descriptions like
+// "YUYV 4:2:2" contain colons without spaces around them
+var fieldSeparator = regexp.MustCompile(`\s+:\s+`)
+
+// probeFormats lists the formats the video device offers. Only V4L2 devices
+// can be probed, ffmpeg lists nothing for other capture backends.
+func (b CaptureBackend) probeFormats() ([]captureFormat, error) {
+	if b.VideoFormat != "v4l2" {
+		return nil, fmt.Errorf("cannot probe %s devices, only v4l2", b.VideoFormat)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	// ffmpeg exits with an error after listing, the listing is what counts
+	output, _ := exec.CommandContext(ctx, "ffmpeg",
+		"-hide_banner",
+		"-f", b.VideoFormat,
+		"-list_formats", "all",
+		"-i", b.VideoDevice,
+	).CombinedOutput()
+
+	formats := parseFormats(string(output))
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("no formats listed for %s: %s", b.VideoDevice, strings.TrimSpace(string(output)))
+	}
+	return formats, nil
+}
+
+func parseFormats(output string) []captureFormat {
+	var formats []captureFormat
+	for _, line := range strings.Split(output, "\n") {
+		match := formatLine.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+
+		fields := fieldSeparator.Split(strings.TrimSpace(match[1]), -1)
+		if len(fields) < 3 {
+			continue
+		}
+		formats = append(formats, captureFormat{
+			Name:  strings.TrimSpace(fields[0]),
+			Sizes: strings.Fields(fields[len(fields)-1]),
+		})
+	}
+	return formats
+}
+
+// findFormat returns the format named name if the device offers it at
+// videoSize.
+func findFormat(formats []captureFormat, name, videoSize string) (captureFormat, bool) {
+	index := slices.IndexFunc(formats, func(format captureFormat) bool {
+		return format.Name == name && format.supports(videoSize)
+	})
+	if index < 0 {
+		return captureFormat{}, false
+	}
+	return formats[index], true
+}
+
+// setKeyframeInterval sets the distance between the keyframes of the device's
+// own H.264 encoder, in frames, through v4l2-ctl. Not every camera exposes
+// it, UVC cameras often keep their own interval.
+func (b CaptureBackend) setKeyframeInterval(frames int) error {
+	if b.VideoFormat != "v4l2" {
+		return fmt.Errorf("cannot configure %s devices, only v4l2", b.VideoFormat)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "v4l2-ctl", "-d", b.VideoDevice, "--list-ctrls").Output()
+	if err != nil {
+		return fmt.Errorf("failed to list controls of %s: %v", b.VideoDevice, err)
+	}
+	var controls []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			controls = append(controls, fields[0])
+		}
+	}
+
+	for _, control := range keyframeControls {
+		if !slices.Contains(controls, control) {
+			continue
+		}
+		value := fmt.Sprintf("%s=%d", control, frames)
+		if output, err := exec.CommandContext(ctx, "v4l2-ctl", "-d", b.VideoDevice, "-c", value).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to set %s: %v: %s", value, err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+	return fmt.Errorf("%s has none of the controls %s", b.VideoDevice, strings.Join(keyframeControls, ", "))
+}
@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"io"
 	"katkam/internal/infrastructure/connectivity"
+	"katkam/internal/infrastructure/connectivity/signaling"
 	"net/http"
 	"sync"
+	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/pion/rtcp"
 	ext_webrtc "github.com/pion/webrtc/v3"
 )
 
@@ -17,18 +19,20 @@ type WebRTCReceiver struct {
 	dataChannel    *ext_webrtc.DataChannel
 	videoTrack     *ext_webrtc.TrackRemote
 	audioTrack     *ext_webrtc.TrackRemote
-	upgrader       websocket.Upgrader
+	signaling      *signaling.Server
+	whip           *signaling.HTTPServer
 	isConnected    bool
 	mutex          sync.RWMutex
+
+	lastKeyframeRequest time.Time
 }
 
+const keyframeRequestInterval = time.Second
+
 func NewWebRTCReceiver() *WebRTCReceiver {
 	return &WebRTCReceiver{
-		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true // Allow all origins for demo
-			},
-		},
+		signaling: signaling.NewServer("Receiver"),
+		whip:      signaling.NewHTTPServer("WHIP"),
 	}
 }
 
@@ -36,11 +40,11 @@ func (r *WebRTCReceiver) Start() error {
 	return nil
 }
 
-func (r *WebRTCReceiver) AssignVideoFrameCallback(fn func([]byte)) {
+func (r *WebRTCReceiver) AssignVideoFrameCallback(fn func(connectivity.Frame)) {
 	r.OnVideoFrame = fn
 }
 
-func (r *WebRTCReceiver) AssignAudioFrameCallback(fn func([]byte)) {
+func (r *WebRTCReceiver) AssignAudioFrameCallback(fn func(connectivity.Frame)) {
 	r.OnAudioFrame = fn
 }
 
@@ -52,7 +56,9 @@ func (r *WebRTCReceiver) AssignDisconnectedCallback(fn func()) {
 	r.OnDisconnected = fn
 }
 
-func (r *WebRTCReceiver) InitializePeerConnection() error {
+// InitializePeerConnection creates the PeerConnection of a new publisher and
+// makes it the current source.
+func (r *WebRTCReceiver) InitializePeerConnection() (*ext_webrtc.PeerConnection, error) {
 	config := ext_webrtc.Configuration{
 		ICEServers: []ext_webrtc.ICEServer{
 			{
@@ -63,14 +69,24 @@ func (r *WebRTCReceiver) InitializePeerConnection() error {
 
 	pc, err := ext_webrtc.NewPeerConnection(config)
 	if err != nil {
-		return fmt.Errorf("failed to create peer connection: %v", err)
+		return nil, fmt.Errorf("failed to create peer connection: %v", err)
 	}
+
+	r.mutex.Lock()
 	r.peerConnection = pc
+	r.mutex.Unlock()
 
 	// Handle incoming tracks
 	pc.OnTrack(func(track *ext_webrtc.TrackRemote, receiver *ext_webrtc.RTPReceiver) {
 		fmt.Printf("Received track: %s, codec: %s\n", track.Kind().String(), track.Codec().MimeType)
 
+		r.mutex.Lock()
+		current := r.peerConnection == pc
+		r.mutex.Unlock()
+		if !current {
+			return
+		}
+
 		if track.Kind() == ext_webrtc.RTPCodecTypeVideo {
 			r.mutex.Lock()
 			r.videoTrack = track
@@ -90,6 +106,11 @@ func (r *WebRTCReceiver) InitializePeerConnection() error {
 		r.mutex.Lock()
 		defer r.mutex.Unlock()
 
+		// A replaced publisher no longer speaks for the source
+		if r.peerConnection != pc {
+			return
+		}
+
 		switch state {
 		case ext_webrtc.PeerConnectionStateConnected:
 			r.isConnected = true
@@ -97,6 +118,9 @@ func (r *WebRTCReceiver) InitializePeerConnection() error {
 				go r.OnConnected()
 			}
 		case ext_webrtc.PeerConnectionStateDisconnected, ext_webrtc.PeerConnectionStateFailed, ext_webrtc.PeerConnectionStateClosed:
+			if !r.isConnected {
+				return
+			}
 			r.isConnected = false
 			if r.OnDisconnected != nil {
 				go r.OnDisconnected()
@@ -109,29 +133,52 @@ func (r *WebRTCReceiver) InitializePeerConnection() error {
 		fmt.Printf("Receiver ICE connection state: %s\n", state.String())
 	})
 
-	return nil
+	return pc, nil
 }
 
 func (r *WebRTCReceiver) handleVideoTrack(track *ext_webrtc.TrackRemote) {
+	assembler, err := newFrameAssembler(track.Codec())
+	if err != nil {
+		fmt.Printf("Cannot relay video track: %v\n", err)
+		return
+	}
+
+	// Ask for a keyframe right away so the stream can start
+	r.requestKeyframe(track)
+
 	for {
 		packet, _, err := track.ReadRTP()
 		if err != nil {
 			if err == io.EOF {
-				fmt.Println("Video track ended")
+				fmt.Printf("Video track ended, %d packets lost\n", assembler.LostPackets())
 				return
 			}
 			fmt.Printf("Error reading video RTP packet: %v\n", err)
 			continue
 		}
 
-		// Forward video packet data to callback if set
-		if r.OnVideoFrame != nil {
-			r.OnVideoFrame(packet.Payload)
+		frames, lost := assembler.Push(packet)
+		if lost {
+			fmt.Printf("⚠️ Video packets lost (%d total), waiting for a keyframe\n", assembler.LostPackets())
+			r.requestKeyframe(track)
+		}
+
+		// Forward complete video frames to callback if set
+		for _, frame := range frames {
+			if r.OnVideoFrame != nil {
+				r.OnVideoFrame(frame)
+			}
 		}
 	}
 }
 
 func (r *WebRTCReceiver) handleAudioTrack(track *ext_webrtc.TrackRemote) {
+	assembler, err := newFrameAssembler(track.Codec())
+	if err != nil {
+		fmt.Printf("Cannot relay audio track: %v\n", err)
+		return
+	}
+
 	for {
 		packet, _, err := track.ReadRTP()
 		if err != nil {
@@ -143,98 +190,107 @@ func (r *WebRTCReceiver) handleAudioTrack(track *ext_webrtc.TrackRemote) {
 			continue
 		}
 
-		// Forward audio packet data to callback if set
-		if r.OnAudioFrame != nil {
-			r.OnAudioFrame(packet.Payload)
+		// Forward audio frames to callback if set
+		frames, _ := assembler.Push(packet)
+		for _, frame := range frames {
+			if r.OnAudioFrame != nil {
+				r.OnAudioFrame(frame)
+			}
 		}
 	}
 }
 
-func (r *WebRTCReceiver) HandleWebSocketConnection(w http.ResponseWriter, req *http.Request) {
-	conn, err := r.upgrader.Upgrade(w, req, nil)
-	if err != nil {
-		fmt.Printf("WebSocket upgrade error: %v\n", err)
-		return
-	}
-	defer conn.Close()
+// RequestKeyframe asks the publisher for a keyframe on behalf of viewers.
+func (r *WebRTCReceiver) RequestKeyframe() {
+	r.mutex.RLock()
+	track := r.videoTrack
+	r.mutex.RUnlock()
 
-	// Initialize peer connection if not already done
-	if r.peerConnection == nil {
-		if err := r.InitializePeerConnection(); err != nil {
-			fmt.Printf("Failed to initialize peer connection: %v\n", err)
-			return
-		}
+	if track != nil {
+		r.requestKeyframe(track)
 	}
+}
 
-	// Handle ICE candidates
-	r.peerConnection.OnICECandidate(func(candidate *ext_webrtc.ICECandidate) {
-		if candidate == nil {
-			return
-		}
+// requestKeyframe sends a Picture Loss Indication to the publisher, at most
+// once per keyframeRequestInterval.
+func (r *WebRTCReceiver) requestKeyframe(track *ext_webrtc.TrackRemote) {
+	r.mutex.Lock()
+	pc := r.peerConnection
+	if pc == nil || time.Since(r.lastKeyframeRequest) < keyframeRequestInterval {
+		r.mutex.Unlock()
+		return
+	}
+	r.lastKeyframeRequest = time.Now()
+	r.mutex.Unlock()
 
-		candidateInit := candidate.ToJSON()
-		if err := conn.WriteJSON(map[string]interface{}{
-			"type":      "ice-candidate",
-			"candidate": candidateInit,
-		}); err != nil {
-			fmt.Printf("Error sending ICE candidate: %v\n", err)
-		}
-	})
+	if err := pc.WriteRTCP([]rtcp.Packet{
+		&rtcp.PictureLossIndication{MediaSSRC: uint32(track.SSRC())},
+	}); err != nil {
+		fmt.Printf("Error sending PLI: %v\n", err)
+	}
+}
 
-	// Listen for signaling messages
-	for {
-		var message map[string]interface{}
-		if err := conn.ReadJSON(&message); err != nil {
-			fmt.Printf("WebSocket read error: %v\n", err)
-			break
-		}
+func (r *WebRTCReceiver) HandleWebSocketConnection(w http.ResponseWriter, req *http.Request) {
+	r.signaling.Serve(w, req, r.newPublisher)
+}
 
-		switch message["type"] {
-		case "offer":
-			offer := ext_webrtc.SessionDescription{
-				Type: ext_webrtc.SDPTypeOffer,
-				SDP:  message["sdp"].(string),
-			}
+// HandleHTTPSignaling serves WHIP, so standard WHIP clients can publish.
+func (r *WebRTCReceiver) HandleHTTPSignaling(w http.ResponseWriter, req *http.Request) {
+	r.whip.Serve(w, req, r.newPublisher)
+}
 
-			if err := r.peerConnection.SetRemoteDescription(offer); err != nil {
-				fmt.Printf("Error setting remote description: %v\n", err)
-				continue
-			}
+// publisher is the signaling peer of a publishing client, ending its
+// session releases the PeerConnection.
+type publisher struct {
+	receiver       *WebRTCReceiver
+	peerConnection *ext_webrtc.PeerConnection
+}
 
-			answer, err := r.peerConnection.CreateAnswer(nil)
-			if err != nil {
-				fmt.Printf("Error creating answer: %v\n", err)
-				continue
-			}
+func (p *publisher) PeerConnection() *ext_webrtc.PeerConnection {
+	return p.peerConnection
+}
 
-			if err := r.peerConnection.SetLocalDescription(answer); err != nil {
-				fmt.Printf("Error setting local description: %v\n", err)
-				continue
-			}
+func (p *publisher) Close() error {
+	return p.receiver.releasePeerConnection(p.peerConnection)
+}
 
-			if err := conn.WriteJSON(map[string]interface{}{
-				"type": "answer",
-				"sdp":  answer.SDP,
-			}); err != nil {
-				fmt.Printf("Error sending answer: %v\n", err)
-			}
+// newPublisher gives a new publishing client its own PeerConnection. There is
+// a single source at a time, so a new publisher replaces the previous one.
+func (r *WebRTCReceiver) newPublisher(req *http.Request) (signaling.Peer, error) {
+	r.mutex.RLock()
+	previous := r.peerConnection
+	r.mutex.RUnlock()
 
-		case "ice-candidate":
-			candidateMap := message["candidate"].(map[string]interface{})
-			sdpMLineIndex := uint16(candidateMap["sdpMLineIndex"].(float64))
-			sdpMid := candidateMap["sdpMid"].(string)
+	if previous != nil {
+		fmt.Printf("Publisher from %s replaces the current one\n", req.RemoteAddr)
+		r.releasePeerConnection(previous)
+	}
 
-			candidate := ext_webrtc.ICECandidateInit{
-				Candidate:     candidateMap["candidate"].(string),
-				SDPMLineIndex: &sdpMLineIndex,
-				SDPMid:        &sdpMid,
-			}
+	pc, err := r.InitializePeerConnection()
+	if err != nil {
+		return nil, err
+	}
+	return &publisher{receiver: r, peerConnection: pc}, nil
+}
 
-			if err := r.peerConnection.AddICECandidate(candidate); err != nil {
-				fmt.Printf("Error adding ICE candidate: %v\n", err)
+// releasePeerConnection closes pc and, if it is the current publisher,
+// reports the source as disconnected.
+func (r *WebRTCReceiver) releasePeerConnection(pc *ext_webrtc.PeerConnection) error {
+	r.mutex.Lock()
+	if r.peerConnection == pc {
+		r.peerConnection = nil
+		r.videoTrack = nil
+		r.audioTrack = nil
+		if r.isConnected {
+			r.isConnected = false
+			if r.OnDisconnected != nil {
+				go r.OnDisconnected()
 			}
 		}
 	}
+	r.mutex.Unlock()
+
+	return pc.Close()
 }
 
 func (r *WebRTCReceiver) IsConnected() bool {
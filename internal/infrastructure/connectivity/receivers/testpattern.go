@@ -0,0 +1,176 @@
+package receivers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"katkam/internal/config"
+	"katkam/internal/infrastructure/connectivity"
+	"net/http"
+	"sync"
+)
+
+// TestPattern is a receiver producing a synthetic VP8 test pattern (and an
+// optional Opus sine tone) with ffmpeg's lavfi sources, so the relay can be
+// exercised on hosts without a camera or a browser publisher.
+type TestPattern struct {
+	connectivity.VideoStreamer
+
+	Config config.TestPattern
+
+	video       *ffmpegProcess
+	audio       *ffmpegProcess
+	isStreaming bool
+	mutex       sync.RWMutex
+}
+
+func NewTestPattern(cfg config.TestPattern) *TestPattern {
+	return &TestPattern{
+		Config: cfg,
+	}
+}
+
+func (t *TestPattern) Start() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.isStreaming {
+		return fmt.Errorf("test pattern is already streaming")
+	}
+
+	video, err := startFFmpeg("test pattern video", t.videoArgs(), t.captureVideoToCallback)
+	if err != nil {
+		return err
+	}
+	t.video = video
+
+	if t.Config.Audio {
+		audio, err := startFFmpeg("test pattern audio", t.audioArgs(), t.captureAudioToCallback)
+		if err != nil {
+			video.Stop()
+			return err
+		}
+		t.audio = audio
+	}
+
+	t.isStreaming = true
+	if t.OnConnected != nil {
+		go t.OnConnected()
+	}
+
+	go t.wait(video)
+
+	return nil
+}
+
+func (t *TestPattern) wait(video *ffmpegProcess) {
+	if err := video.Wait(); err != nil {
+		fmt.Printf("❌ Test pattern stopped: %v\n", err)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.video != video {
+		return
+	}
+	if t.audio != nil {
+		t.audio.Stop()
+		t.audio = nil
+	}
+	t.video = nil
+	t.isStreaming = false
+	if t.OnDisconnected != nil {
+		go t.OnDisconnected()
+	}
+}
+
+func (t *TestPattern) videoArgs() []string {
+	source := fmt.Sprintf("%s=size=%dx%d:rate=%d", t.Config.Pattern, t.Config.Width, t.Config.Height, t.Config.Framerate)
+	return []string{
+		"-re", // Read the source at its native rate, lavfi is otherwise unthrottled
+		"-f", "lavfi",
+		"-i", source,
+		"-c:v", "libvpx",
+		"-g", fmt.Sprintf("%d", t.Config.Framerate), // Keyframe every second
+		"-b:v", "500k",
+		"-deadline", "realtime",
+		"-cpu-used", "8",
+		"-f", "ivf",
+		"-",
+	}
+}
+
+func (t *TestPattern) audioArgs() []string {
+	source := fmt.Sprintf("sine=frequency=%d:sample_rate=48000", t.Config.ToneFrequency)
+	return []string{
+		"-re",
+		"-f", "lavfi",
+		"-i", source,
+		"-c:a", "libopus",
+		"-b:a", "32k",
+		"-frame_duration", "20",
+		"-page_duration", "20000", // One 20ms Opus packet per Ogg page
+		"-f", "ogg",
+		"-",
+	}
+}
+
+func (t *TestPattern) captureVideoToCallback(ctx context.Context, reader io.Reader) {
+	readIVFFrames(ctx, reader, func(frame connectivity.Frame) {
+		if t.OnVideoFrame != nil {
+			t.OnVideoFrame(frame)
+		}
+	})
+}
+
+func (t *TestPattern) captureAudioToCallback(ctx context.Context, reader io.Reader) {
+	readOggOpusPackets(ctx, reader, func(frame connectivity.Frame) {
+		if t.OnAudioFrame != nil {
+			t.OnAudioFrame(frame)
+		}
+	})
+}
+
+func (t *TestPattern) AssignVideoFrameCallback(fn func(connectivity.Frame)) {
+	t.OnVideoFrame = fn
+}
+
+func (t *TestPattern) AssignAudioFrameCallback(fn func(connectivity.Frame)) {
+	t.OnAudioFrame = fn
+}
+
+func (t *TestPattern) AssignConnectedCallback(fn func()) {
+	t.OnConnected = fn
+}
+
+func (t *TestPattern) AssignDisconnectedCallback(fn func()) {
+	t.OnDisconnected = fn
+}
+
+func (t *TestPattern) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.video != nil {
+		t.video.Stop()
+	}
+	if t.audio != nil {
+		t.audio.Stop()
+	}
+	return nil
+}
+
+func (t *TestPattern) HandleWebSocketConnection(w http.ResponseWriter, req *http.Request) {
+	http.Error(w, "Test pattern receiver does not accept publishers", http.StatusConflict)
+}
+
+// RequestKeyframe is a no-op, the pattern is encoded with a short keyframe
+// interval instead.
+func (t *TestPattern) RequestKeyframe() {}
+
+func (t *TestPattern) IsConnected() bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.isStreaming
+}
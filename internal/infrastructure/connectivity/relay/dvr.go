@@ -0,0 +1,119 @@
+package relay
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"katkam/internal/config"
+	"katkam/internal/infrastructure/connectivity"
+)
+
+// dvrBuffer keeps the relayed frames of the last duration in memory, bounded
+// by maxBytes. Frames are evicted from the front as they age out.
+type dvrBuffer struct {
+	duration time.Duration
+	maxBytes int
+
+	mutex  sync.RWMutex
+	frames []connectivity.TimedFrame
+	first  uint64 // Sequence of frames[0]
+	bytes  int
+}
+
+func newDVRBuffer(duration time.Duration, maxBytes int) *dvrBuffer {
+	return &dvrBuffer{
+		duration: duration,
+		maxBytes: maxBytes,
+	}
+}
+
+func (b *dvrBuffer) add(frame connectivity.Frame, video bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	b.frames = append(b.frames, connectivity.TimedFrame{
+		Frame:      frame,
+		Video:      video,
+		ReceivedAt: now,
+		Sequence:   b.first + uint64(len(b.frames)),
+	})
+	b.bytes += len(frame.Data)
+
+	for len(b.frames) > 0 && (now.Sub(b.frames[0].ReceivedAt) > b.duration || b.bytes > b.maxBytes) {
+		b.bytes -= len(b.frames[0].Data)
+		// Let go of the payload, the backing array is only dropped on the
+		// next reallocation
+		b.frames[0] = connectivity.TimedFrame{}
+		b.frames = b.frames[1:]
+		b.first++
+	}
+}
+
+func (b *dvrBuffer) Seek(at time.Time) (uint64, time.Time, bool) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	found := false
+	var sequence uint64
+	var receivedAt time.Time
+	var distance time.Duration
+	for _, frame := range b.frames {
+		if !frame.Video || !frame.Keyframe {
+			continue
+		}
+		d := frame.ReceivedAt.Sub(at)
+		if d < 0 {
+			d = -d
+		}
+		if found && d >= distance {
+			// Keyframes are in order, so they only get further away
+			break
+		}
+		found, sequence, receivedAt, distance = true, frame.Sequence, frame.ReceivedAt, d
+	}
+	return sequence, receivedAt, found
+}
+
+func (b *dvrBuffer) ReadFrames(sequence uint64, max int) ([]connectivity.TimedFrame, bool) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if sequence < b.first {
+		return nil, false
+	}
+	start := sequence - b.first
+	if start >= uint64(len(b.frames)) {
+		return nil, true
+	}
+
+	end := min(start+uint64(max), uint64(len(b.frames)))
+	frames := make([]connectivity.TimedFrame, end-start)
+	copy(frames, b.frames[start:end])
+	return frames, true
+}
+
+func (b *dvrBuffer) status() map[string]interface{} {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	buffered := 0.0
+	if len(b.frames) > 0 {
+		buffered = b.frames[len(b.frames)-1].ReceivedAt.Sub(b.frames[0].ReceivedAt).Seconds()
+	}
+	return map[string]interface{}{
+		"buffered_seconds": buffered,
+		"bytes":            b.bytes,
+	}
+}
+
+// EnableDVR keeps the last minutes of the stream in memory and lets viewers
+// watch them timeshifted. The returned buffer can be read by others as well.
+// Must be called before the relay starts.
+func (r *WebRTCRelay) EnableDVR(cfg config.DVR) connectivity.Timeshifter {
+	r.dvr = newDVRBuffer(time.Duration(cfg.Duration)*time.Second, cfg.MaxSizeMB*1024*1024)
+	r.sender.AssignTimeshifter(r.dvr)
+	fmt.Printf("⏪ DVR keeps the last %d seconds of the stream\n", cfg.Duration)
+	return r.dvr
+}
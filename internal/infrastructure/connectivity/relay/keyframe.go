@@ -0,0 +1,64 @@
+package relay
+
+import (
+	"time"
+
+	"katkam/internal/infrastructure/connectivity"
+)
+
+const (
+	// The group of pictures is cached up to these limits, past them new
+	// viewers wait for the next keyframe instead
+	maxCachedFrames = 300
+	maxCachedBytes  = 16 * 1024 * 1024
+
+	keyframeRequestInterval = 500 * time.Millisecond
+)
+
+// cacheVideoFrame keeps the frames since the last keyframe so late joining
+// viewers can start decoding right away. Must be called with frameMutex held.
+func (r *WebRTCRelay) cacheVideoFrame(frame connectivity.Frame) {
+	if frame.Keyframe {
+		r.groupOfPictures = append(r.groupOfPictures[:0], frame)
+		r.cachedBytes = len(frame.Data)
+		return
+	}
+
+	// Nothing to build on until the first keyframe, or the cache overflowed
+	if len(r.groupOfPictures) == 0 {
+		return
+	}
+	if len(r.groupOfPictures) >= maxCachedFrames || r.cachedBytes+len(frame.Data) > maxCachedBytes {
+		r.clearGroupOfPictures()
+		return
+	}
+
+	r.groupOfPictures = append(r.groupOfPictures, frame)
+	r.cachedBytes += len(frame.Data)
+}
+
+func (r *WebRTCRelay) clearGroupOfPictures() {
+	r.groupOfPictures = nil
+	r.cachedBytes = 0
+}
+
+func (r *WebRTCRelay) WithGroupOfPictures(fn func(frames []connectivity.Frame)) {
+	r.frameMutex.Lock()
+	defer r.frameMutex.Unlock()
+
+	fn(r.groupOfPictures)
+}
+
+// RequestKeyframe forwards keyframe requests from viewers (PLI/FIR) to the
+// receiver, coalescing bursts from several viewers.
+func (r *WebRTCRelay) RequestKeyframe() {
+	r.mutex.Lock()
+	if time.Since(r.lastKeyframeRequest) < keyframeRequestInterval {
+		r.mutex.Unlock()
+		return
+	}
+	r.lastKeyframeRequest = time.Now()
+	r.mutex.Unlock()
+
+	r.receiver.RequestKeyframe()
+}
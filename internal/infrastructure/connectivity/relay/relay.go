@@ -3,6 +3,7 @@ package relay
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"katkam/internal/infrastructure/connectivity"
 )
@@ -10,8 +11,21 @@ import (
 type WebRTCRelay struct {
 	sender   connectivity.Sender
 	receiver connectivity.Receiver
+	sinks    []connectivity.Sink
 	mutex    sync.RWMutex
 	isActive bool
+
+	lastKeyframeRequest time.Time
+
+	// frameMutex serialises relayed video frames with viewers being primed
+	// from the cached group of pictures
+	frameMutex      sync.Mutex
+	groupOfPictures []connectivity.Frame
+	cachedBytes     int
+	videoCodec      string // Of the last relayed video frame
+
+	// dvr is nil unless EnableDVR was called
+	dvr *dvrBuffer
 }
 
 func NewWebRTCRelay(receiver connectivity.Receiver, sender connectivity.Sender) *WebRTCRelay {
@@ -24,10 +38,20 @@ func NewWebRTCRelay(receiver connectivity.Receiver, sender connectivity.Sender)
 	relay.receiver.AssignAudioFrameCallback(relay.relayAudioFrame)
 	relay.receiver.AssignConnectedCallback(relay.onReceiverConnected)
 	relay.receiver.AssignDisconnectedCallback(relay.onReceiverDisconnected)
+	relay.sender.AssignKeyframeProvider(relay)
 
 	return relay
 }
 
+// AddSink hands the relayed stream to sink as well. Sinks must be added
+// before the relay starts.
+func (r *WebRTCRelay) AddSink(sink connectivity.Sink) {
+	if consumer, ok := sink.(connectivity.KeyframeConsumer); ok {
+		consumer.AssignKeyframeProvider(r)
+	}
+	r.sinks = append(r.sinks, sink)
+}
+
 func (s *WebRTCRelay) Start() {
 	err := s.receiver.Start()
 	if err != nil {
@@ -39,15 +63,35 @@ func (s *WebRTCRelay) Start() {
 	}
 }
 
-func (r *WebRTCRelay) relayVideoFrame(data []byte) {
+func (r *WebRTCRelay) relayVideoFrame(frame connectivity.Frame) {
+	r.frameMutex.Lock()
+	defer r.frameMutex.Unlock()
+
+	if frame.Codec != r.videoCodec {
+		fmt.Printf("WebRTC Relay: Source sends %s video\n", frame.Codec)
+		r.videoCodec = frame.Codec
+	}
+	r.cacheVideoFrame(frame)
+	if r.dvr != nil {
+		r.dvr.add(frame, true)
+	}
 	if r.sender.IsConnected() {
-		r.sender.SendVideoFrame(data)
+		r.sender.SendVideoFrame(frame)
+	}
+	for _, sink := range r.sinks {
+		sink.SendVideoFrame(frame)
 	}
 }
 
-func (r *WebRTCRelay) relayAudioFrame(data []byte) {
+func (r *WebRTCRelay) relayAudioFrame(frame connectivity.Frame) {
+	if r.dvr != nil {
+		r.dvr.add(frame, false)
+	}
 	if r.sender.IsConnected() {
-		r.sender.SendAudioFrame(data)
+		r.sender.SendAudioFrame(frame)
+	}
+	for _, sink := range r.sinks {
+		sink.SendAudioFrame(frame)
 	}
 }
 
@@ -56,13 +100,30 @@ func (r *WebRTCRelay) onReceiverConnected() {
 	defer r.mutex.Unlock()
 	r.isActive = true
 	fmt.Println("WebRTC Relay: Receiver connected, relay is now active")
+
+	r.notifySinks(connectivity.SourceObserver.SourceConnected)
 }
 
 func (r *WebRTCRelay) onReceiverDisconnected() {
 	r.mutex.Lock()
-	defer r.mutex.Unlock()
 	r.isActive = false
+	r.mutex.Unlock()
 	fmt.Println("WebRTC Relay: Receiver disconnected, relay is now inactive")
+
+	r.frameMutex.Lock()
+	r.clearGroupOfPictures()
+	r.frameMutex.Unlock()
+
+	r.notifySinks(connectivity.SourceObserver.SourceDisconnected)
+}
+
+// notifySinks calls notify on the sinks observing the source.
+func (r *WebRTCRelay) notifySinks(notify func(connectivity.SourceObserver)) {
+	for _, sink := range r.sinks {
+		if observer, ok := sink.(connectivity.SourceObserver); ok {
+			notify(observer)
+		}
+	}
 }
 
 func (r *WebRTCRelay) GetReceiver() connectivity.Socket {
@@ -83,10 +144,41 @@ func (r *WebRTCRelay) GetStatus() map[string]interface{} {
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
 
-	return map[string]interface{}{
+	status := map[string]interface{}{
 		"relay_active":       r.isActive,
 		"receiver_connected": r.receiver.IsConnected(),
 		"sender_connected":   r.sender.IsConnected(),
+		"viewers":            r.sender.Viewers(),
+	}
+	r.frameMutex.Lock()
+	status["video_codec"] = r.videoCodec
+	r.frameMutex.Unlock()
+	if r.dvr != nil {
+		status["dvr"] = r.dvr.status()
+	}
+	if reporter, ok := r.sender.(connectivity.StatusReporter); ok {
+		for key, value := range reporter.Status() {
+			status[key] = value
+		}
+	}
+	for _, sink := range r.sinks {
+		if reporter, ok := sink.(connectivity.StatusReporter); ok {
+			for key, value := range reporter.Status() {
+				status[key] = value
+			}
+		}
+	}
+	return status
+}
+
+// GetHealth returns the part of the status that is safe to show anyone.
+func (r *WebRTCRelay) GetHealth() map[string]interface{} {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return map[string]interface{}{
+		"relay_active":       r.isActive,
+		"receiver_connected": r.receiver.IsConnected(),
 	}
 }
 
@@ -99,6 +191,11 @@ func (r *WebRTCRelay) Close() error {
 	var receiverErr, senderErr error
 	receiverErr = r.receiver.Close()
 	senderErr = r.sender.Close()
+	for _, sink := range r.sinks {
+		if err := sink.Close(); err != nil {
+			fmt.Printf("Error closing sink: %v\n", err)
+		}
+	}
 
 	if receiverErr != nil {
 		return fmt.Errorf("receiver close error: %v", receiverErr)
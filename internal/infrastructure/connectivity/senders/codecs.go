@@ -0,0 +1,168 @@
+package senders
+
+import (
+	"fmt"
+	"katkam/internal/infrastructure/connectivity"
+	"katkam/internal/infrastructure/media/h264"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	ext_webrtc "github.com/pion/webrtc/v3"
+)
+
+// defaultProfileLevelID is constrained baseline, which every browser with
+// H.264 decodes, announced until the source sent its SPS
+const defaultProfileLevelID = "42e01f"
+
+// h264FmtpLine announces H.264 in non-interleaved mode, which the payloader
+// writes.
+func h264FmtpLine(profileLevelID string) string {
+	return "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=" + profileLevelID
+}
+
+// h264ProfileLevelID returns the profile-level-id announcing H.264 with an
+// SPS of profile, constraints and level. Browsers negotiate a handful of
+// profiles and tell them apart by the first two bytes, so the constraint flags
+// are those of the browsers' profile the stream decodes with.
+func h264ProfileLevelID(profile, constraints, level byte) (string, bool) {
+	var profileIOP byte
+	switch profile {
+	case 0x42: // Baseline
+		if constraints&0x40 != 0 {
+			// constraint_set1_flag makes it constrained baseline
+			profileIOP = 0xe0
+		}
+	case 0x4d, 0x64: // Main and High
+	default:
+		return "", false
+	}
+	return fmt.Sprintf("%02x%02x%02x", profile, profileIOP, level), true
+}
+
+// videoCodecCapability returns the track capability and payloader of a video
+// codec viewers can receive, H.264 in the profile of profileLevelID.
+func videoCodecCapability(codec, profileLevelID string) (ext_webrtc.RTPCodecCapability, rtp.Payloader, error) {
+	switch codec {
+	case connectivity.CodecVP8:
+		return ext_webrtc.RTPCodecCapability{MimeType: ext_webrtc.MimeTypeVP8, ClockRate: 90000},
+			&codecs.VP8Payloader{EnablePictureID: true}, nil
+	case connectivity.CodecH264:
+		return ext_webrtc.RTPCodecCapability{MimeType: ext_webrtc.MimeTypeH264, ClockRate: 90000, SDPFmtpLine: h264FmtpLine(profileLevelID)},
+			&codecs.H264Payloader{}, nil
+	}
+	return ext_webrtc.RTPCodecCapability{}, nil, fmt.Errorf("unsupported video codec %s", codec)
+}
+
+// offeredVideoCodecs returns the video codecs of an offer that viewers can
+// receive, in the order of the client's preference. H.264 is only taken in
+// packetization mode 1, which the payloader writes, and in the profile of
+// profileLevelID, the level does not need to match.
+func offeredVideoCodecs(offerSDP, profileLevelID string) ([]string, error) {
+	offer := ext_webrtc.SessionDescription{Type: ext_webrtc.SDPTypeOffer, SDP: offerSDP}
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse offer: %v", err)
+	}
+
+	var offered []string
+	for _, media := range parsed.MediaDescriptions {
+		if media.MediaName.Media != "video" {
+			continue
+		}
+		for _, format := range media.MediaName.Formats {
+			payloadType, err := strconv.ParseUint(format, 10, 8)
+			if err != nil {
+				continue
+			}
+			codec, err := parsed.GetCodecForPayloadType(uint8(payloadType))
+			if err != nil {
+				continue
+			}
+
+			var mimeType string
+			switch {
+			case strings.EqualFold(codec.Name, "VP8"):
+				mimeType = connectivity.CodecVP8
+			case strings.EqualFold(codec.Name, "H264") && strings.Contains(codec.Fmtp, "packetization-mode=1") &&
+				strings.Contains(strings.ToLower(codec.Fmtp), "profile-level-id="+profileLevelID[:4]):
+				mimeType = connectivity.CodecH264
+			default:
+				continue
+			}
+			if !slices.Contains(offered, mimeType) {
+				offered = append(offered, mimeType)
+			}
+		}
+		// A single video track is sent
+		break
+	}
+	return offered, nil
+}
+
+// chooseVideoCodec picks the codec of a viewer's video among those it
+// offered: the source's, so frames are relayed as they are, or else VP8
+// transcoded from it.
+func (s *WebRTCSender) chooseVideoCodec(offered []string) (string, error) {
+	source := s.sourceVideoCodec()
+	if slices.Contains(offered, source) {
+		return source, nil
+	}
+	if slices.Contains(offered, connectivity.CodecVP8) {
+		return connectivity.CodecVP8, nil
+	}
+	return "", fmt.Errorf("viewer supports neither %s nor %s", source, connectivity.CodecVP8)
+}
+
+// sourceVideoCodec returns the codec the source sends, going by the cached
+// group of pictures or else the last relayed frame. It is VP8 until the
+// source sent any video.
+func (s *WebRTCSender) sourceVideoCodec() string {
+	codec := ""
+	if s.keyframeProvider != nil {
+		s.keyframeProvider.WithGroupOfPictures(func(frames []connectivity.Frame) {
+			if len(frames) > 0 {
+				codec = frames[0].Codec
+			}
+		})
+	}
+	if codec == "" {
+		codec, _ = s.sourceCodec.Load().(string)
+	}
+	if codec == "" {
+		codec = connectivity.CodecVP8
+	}
+	return codec
+}
+
+// sourceProfileLevelID returns the profile-level-id of the source's H.264,
+// going by the SPS of its last keyframe.
+func (s *WebRTCSender) sourceProfileLevelID() string {
+	if profileLevelID, _ := s.sourceProfile.Load().(string); profileLevelID != "" {
+		return profileLevelID
+	}
+	return defaultProfileLevelID
+}
+
+// updateSourceProfile remembers the profile of the source's H.264 keyframes,
+// viewers are offered the profile they carry.
+func (s *WebRTCSender) updateSourceProfile(frame connectivity.Frame) {
+	if frame.Codec != connectivity.CodecH264 || !frame.Keyframe {
+		return
+	}
+	profile, constraints, level, ok := h264.ProfileLevel(frame.Data)
+	if !ok {
+		return
+	}
+	profileLevelID, ok := h264ProfileLevelID(profile, constraints, level)
+	if !ok {
+		// Left to the VP8 transcode, browsers do not decode other profiles
+		profileLevelID = fmt.Sprintf("%02x%02x%02x", profile, constraints, level)
+	}
+	if previous, _ := s.sourceProfile.Load().(string); previous != profileLevelID {
+		fmt.Printf("Source sends H.264 with profile-level-id %s\n", profileLevelID)
+		s.sourceProfile.Store(profileLevelID)
+	}
+}
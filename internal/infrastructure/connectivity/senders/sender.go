@@ -2,306 +2,352 @@ package senders
 
 import (
 	"fmt"
+	"katkam/internal/config"
+	"katkam/internal/infrastructure/connectivity"
+	"katkam/internal/infrastructure/connectivity/signaling"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/gorilla/websocket"
 	ext_webrtc "github.com/pion/webrtc/v3"
-	"github.com/pion/webrtc/v3/pkg/media"
 )
 
+// WebRTCSender fans the relayed stream out to any number of viewers, each
+// with its own PeerConnection. Every viewer gets the video in a codec picked
+// from its offer, the source's when it supports it and VP8 transcoded from
+// the source otherwise.
 type WebRTCSender struct {
-	peerConnection *ext_webrtc.PeerConnection
-	videoTrack     *ext_webrtc.TrackLocalStaticSample
-	audioTrack     *ext_webrtc.TrackLocalStaticSample
-	upgrader       websocket.Upgrader
-	isConnected    bool
-	mutex          sync.RWMutex
-
-	// Channel for receiving video/audio data to send
-	videoChannel chan []byte
-	audioChannel chan []byte
-	stopChannel  chan struct{}
+	viewers          map[string]*viewer
+	keyframeProvider connectivity.KeyframeProvider
+	timeshifter      connectivity.Timeshifter
+	talkback         *Talkback
+	signaling        *signaling.Server
+	whep             *signaling.HTTPServer
+	mutex            sync.RWMutex
+
+	// sourceCodec is the codec of the last relayed video frame
+	sourceCodec atomic.Value
+	// sourceProfile is the profile-level-id of the source's H.264
+	sourceProfile atomic.Value
 }
 
 func NewWebRTCSender() *WebRTCSender {
 	return &WebRTCSender{
-		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true // Allow all origins for demo
-			},
-		},
-		videoChannel: make(chan []byte, 100),
-		audioChannel: make(chan []byte, 100),
-		stopChannel:  make(chan struct{}),
+		viewers:   make(map[string]*viewer),
+		signaling: signaling.NewServer("Sender"),
+		whep:      signaling.NewHTTPServer("WHEP"),
 	}
 }
 
-func (r *WebRTCSender) Start() error {
-	return r.InitializePeerConnection()
+func (s *WebRTCSender) Start() error {
+	return nil
 }
 
-func (s *WebRTCSender) InitializePeerConnection() error {
-	fmt.Println("Initializing sender...")
-	config := ext_webrtc.Configuration{
-		ICEServers: []ext_webrtc.ICEServer{
-			{
-				URLs: []string{
-					"stun:stun.l.google.com:19302",
-					"stun:stun1.l.google.com:19302",
-				},
-			},
-		},
-		ICECandidatePoolSize: 10,
-	}
+func (s *WebRTCSender) AssignKeyframeProvider(provider connectivity.KeyframeProvider) {
+	s.keyframeProvider = provider
+}
 
-	pc, err := ext_webrtc.NewPeerConnection(config)
+// AssignTimeshifter lets viewers watch the stream with a delay, without one
+// timeshift requests are rejected.
+func (s *WebRTCSender) AssignTimeshifter(timeshifter connectivity.Timeshifter) {
+	s.timeshifter = timeshifter
+}
+
+// EnableTalkback plays the microphone of the viewer holding the floor on
+// the host's speaker. Must be called before viewers join.
+func (s *WebRTCSender) EnableTalkback(cfg config.Talkback) error {
+	talkback, err := NewTalkback(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to create peer connection: %v", err)
+		return err
 	}
-	s.peerConnection = pc
-
-	// Create video track
-	videoTrack, err := ext_webrtc.NewTrackLocalStaticSample(
-		ext_webrtc.RTPCodecCapability{MimeType: ext_webrtc.MimeTypeVP8},
-		"video",
-		"relay-video",
-	)
-	if err != nil {
-		return fmt.Errorf("failed to create video track: %v", err)
+	s.talkback = talkback
+	return nil
+}
+
+func (s *WebRTCSender) requestKeyframe() {
+	if s.keyframeProvider != nil {
+		s.keyframeProvider.RequestKeyframe()
 	}
-	s.videoTrack = videoTrack
-
-	// Create audio track
-	audioTrack, err := ext_webrtc.NewTrackLocalStaticSample(
-		ext_webrtc.RTPCodecCapability{MimeType: ext_webrtc.MimeTypeOpus},
-		"audio",
-		"relay-audio",
-	)
+}
+
+func (s *WebRTCSender) addViewer(remoteAddr string) (*viewer, error) {
+	v, err := newViewer(remoteAddr, s.talkback != nil, s.onViewerStateChange, s.requestKeyframe)
 	if err != nil {
-		return fmt.Errorf("failed to create audio track: %v", err)
+		return nil, err
 	}
-	s.audioTrack = audioTrack
 
-	// Add tracks to peer connection
-	if _, err = pc.AddTrack(videoTrack); err != nil {
-		return fmt.Errorf("failed to add video track: %v", err)
+	if s.talkback != nil {
+		v.peerConnection.OnTrack(func(track *ext_webrtc.TrackRemote, _ *ext_webrtc.RTPReceiver) {
+			if track.Kind() == ext_webrtc.RTPCodecTypeAudio {
+				s.talkback.receive(v.id, track)
+			}
+		})
 	}
 
-	if _, err = pc.AddTrack(audioTrack); err != nil {
-		return fmt.Errorf("failed to add audio track: %v", err)
+	s.mutex.Lock()
+	s.viewers[v.id] = v
+	count := len(s.viewers)
+	s.mutex.Unlock()
+
+	fmt.Printf("Viewer %s joined from %s (%d viewers)\n", v.id, remoteAddr, count)
+	return v, nil
+}
+
+func (s *WebRTCSender) removeViewer(v *viewer) {
+	s.mutex.Lock()
+	_, found := s.viewers[v.id]
+	delete(s.viewers, v.id)
+	count := len(s.viewers)
+	s.mutex.Unlock()
+
+	if s.talkback != nil {
+		s.talkback.Release(v.id)
+	}
+	if err := v.Close(); err != nil {
+		fmt.Printf("Error closing viewer %s: %v\n", v.id, err)
+	}
+	if found {
+		fmt.Printf("Viewer %s left (%d viewers)\n", v.id, count)
 	}
+}
 
-	// Handle connection state changes
-	pc.OnConnectionStateChange(func(state ext_webrtc.PeerConnectionState) {
-		fmt.Printf("Sender connection state: %s\n", state.String())
-		s.mutex.Lock()
-		defer s.mutex.Unlock()
-
-		switch state {
-		case ext_webrtc.PeerConnectionStateConnected:
-			fmt.Println("Sender Connected")
-			s.isConnected = true
-			// Start streaming goroutines
-			go s.streamVideo()
-			go s.streamAudio()
-		case ext_webrtc.PeerConnectionStateDisconnected, ext_webrtc.PeerConnectionStateFailed, ext_webrtc.PeerConnectionStateClosed:
-			fmt.Println("Sender Disconnected")
-			s.isConnected = false
-		}
-	})
+func (s *WebRTCSender) onViewerStateChange(v *viewer, state ext_webrtc.PeerConnectionState) {
+	switch state {
+	case ext_webrtc.PeerConnectionStateConnected:
+		s.primeViewer(v)
+	case ext_webrtc.PeerConnectionStateFailed, ext_webrtc.PeerConnectionStateClosed:
+		go s.removeViewer(v)
+	}
+}
 
-	// Handle ICE connection state changes
-	pc.OnICEConnectionStateChange(func(state ext_webrtc.ICEConnectionState) {
-		fmt.Printf("Sender ICE connection state: %s\n", state.String())
-	})
+// primeViewer starts a newly connected viewer on the cached group of
+// pictures, or asks the source for a keyframe when there is none.
+func (s *WebRTCSender) primeViewer(v *viewer) {
+	if s.keyframeProvider == nil {
+		return
+	}
 
-	// Handle ICE gathering state changes
-	pc.OnICEGatheringStateChange(func(state ext_webrtc.ICEGathererState) {
-		fmt.Printf("Sender ICE gathering state: %s\n", state.String())
+	primed := false
+	s.keyframeProvider.WithGroupOfPictures(func(frames []connectivity.Frame) {
+		primed = v.Prime(frames)
+		if primed {
+			fmt.Printf("Viewer %s primed with %d cached frames\n", v.id, len(frames))
+		}
 	})
 
-	return nil
+	if !primed {
+		s.requestKeyframe()
+	}
 }
 
-func (s *WebRTCSender) streamVideo() {
-	ticker := time.NewTicker(33 * time.Millisecond) // ~30 FPS
-	defer ticker.Stop()
-
-	framesSent := 0
-	for {
-		select {
-		case <-s.stopChannel:
-			fmt.Printf("🛑 Video streaming stopped. Total frames sent: %d\n", framesSent)
-			return
-		case videoData := <-s.videoChannel:
-			if s.isConnected && s.videoTrack != nil {
-				if err := s.videoTrack.WriteSample(media.Sample{
-					Data:     videoData,
-					Duration: 33 * time.Millisecond,
-				}); err != nil {
-					fmt.Printf("❌ Error writing video sample: %v\n", err)
-				}
-			} else {
-				fmt.Printf("⚠️ Cannot send video: connected=%v, track=%v\n", s.isConnected, s.videoTrack != nil)
-			}
-		case <-ticker.C:
-			// If no video data is available, continue to maintain frame rate
-		}
+func (s *WebRTCSender) SendVideoFrame(frame connectivity.Frame) {
+	s.sourceCodec.Store(frame.Codec)
+	s.updateSourceProfile(frame)
+	s.sendVideoFrame(frame)
+}
+
+// SendTranscodedVideoFrame hands a frame transcoded from the source to the
+// viewers that cannot decode the source's codec.
+func (s *WebRTCSender) SendTranscodedVideoFrame(frame connectivity.Frame) {
+	if source, _ := s.sourceCodec.Load().(string); frame.Codec == source {
+		// The source itself reaches these viewers
+		return
 	}
+	s.sendVideoFrame(frame)
 }
 
-func (s *WebRTCSender) streamAudio() {
-	ticker := time.NewTicker(20 * time.Millisecond) // 50 FPS audio
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-s.stopChannel:
-			return
-		case audioData := <-s.audioChannel:
-			if s.isConnected && s.audioTrack != nil {
-				if err := s.audioTrack.WriteSample(media.Sample{
-					Data:     audioData,
-					Duration: 20 * time.Millisecond,
-				}); err != nil {
-					fmt.Printf("Error writing audio sample: %v\n", err)
-				}
-			}
-		case <-ticker.C:
-			// If no audio data is available, continue
+func (s *WebRTCSender) sendVideoFrame(frame connectivity.Frame) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, v := range s.viewers {
+		if v.IsConnected() && v.VideoCodec() == frame.Codec {
+			v.SendVideoFrame(frame)
 		}
 	}
 }
 
-func (s *WebRTCSender) SendVideoFrame(data []byte) {
-	select {
-	case s.videoChannel <- data:
-		// Successfully sent to channel
-	default:
-		// Channel is full, drop frame
-		fmt.Println("⚠️ Video channel full, dropping frame")
+// NeedsTranscoding reports whether a viewer watches live in codec while the
+// source sends another one.
+func (s *WebRTCSender) NeedsTranscoding(codec string) bool {
+	if source, _ := s.sourceCodec.Load().(string); codec == source {
+		return false
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, v := range s.viewers {
+		if v.IsConnected() && v.VideoCodec() == codec && !v.timeshifted.Load() {
+			return true
+		}
 	}
+	return false
 }
 
-func (s *WebRTCSender) SendAudioFrame(data []byte) {
-	select {
-	case s.audioChannel <- data:
-		// Successfully sent
-	default:
-		// Channel is full, drop frame
-		fmt.Println("Audio channel full, dropping frame")
+func (s *WebRTCSender) SendAudioFrame(frame connectivity.Frame) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, v := range s.viewers {
+		if v.IsConnected() {
+			v.SendAudioFrame(frame)
+		}
 	}
 }
 
-func (s *WebRTCSender) HandleWebSocketConnection(w http.ResponseWriter, req *http.Request) {
-	conn, err := s.upgrader.Upgrade(w, req, nil)
-	if err != nil {
-		fmt.Printf("WebSocket upgrade error: %v\n", err)
-		return
+// Status reports the talkback in the status API, when enabled.
+func (s *WebRTCSender) Status() map[string]interface{} {
+	if s.talkback == nil {
+		return nil
 	}
-	defer conn.Close()
+	return s.talkback.Status()
+}
 
-	fmt.Printf("WebSocket connection established from %s\n", req.RemoteAddr)
+func (s *WebRTCSender) Viewers() []connectivity.ViewerStats {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 
-	// Always create a new peer connection for each WebSocket connection
-	if err := s.InitializePeerConnection(); err != nil {
-		fmt.Printf("Failed to initialize peer connection: %v\n", err)
-		return
+	stats := make([]connectivity.ViewerStats, 0, len(s.viewers))
+	for _, v := range s.viewers {
+		stats = append(stats, v.Stats())
 	}
-	fmt.Printf("Peer connection initialized successfully\n")
-
-	// Handle ICE candidates
-	s.peerConnection.OnICECandidate(func(candidate *ext_webrtc.ICECandidate) {
-		if candidate == nil {
-			return
-		}
+	return stats
+}
 
-		candidateInit := candidate.ToJSON()
-		if err := conn.WriteJSON(map[string]interface{}{
-			"type":      "ice-candidate",
-			"candidate": candidateInit,
-		}); err != nil {
-			fmt.Printf("Error sending ICE candidate: %v\n", err)
-		}
-	})
+func (s *WebRTCSender) HandleWebSocketConnection(w http.ResponseWriter, req *http.Request) {
+	s.signaling.Serve(w, req, s.newViewerPeer)
+}
 
-	// Listen for signaling messages
-	for {
-		var message map[string]interface{}
-		if err := conn.ReadJSON(&message); err != nil {
-			fmt.Printf("WebSocket read error: %v\n", err)
-			break
-		}
+// HandleHTTPSignaling serves WHEP, so standard WHEP players can watch.
+func (s *WebRTCSender) HandleHTTPSignaling(w http.ResponseWriter, req *http.Request) {
+	s.whep.Serve(w, req, s.newViewerPeer)
+}
 
-		switch message["type"] {
-		case "offer":
-			fmt.Printf("Received offer from client\n")
-			offer := ext_webrtc.SessionDescription{
-				Type: ext_webrtc.SDPTypeOffer,
-				SDP:  message["sdp"].(string),
-			}
+// viewerPeer ties a viewer to its signaling session, the viewer lives as long
+// as the session does.
+type viewerPeer struct {
+	*viewer
+	sender *WebRTCSender
+}
 
-			fmt.Printf("Setting remote description...\n")
-			if err := s.peerConnection.SetRemoteDescription(offer); err != nil {
-				fmt.Printf("Error setting remote description: %v\n", err)
-				continue
-			}
+func (p viewerPeer) Close() error {
+	p.sender.removeViewer(p.viewer)
+	return nil
+}
 
-			fmt.Printf("Creating answer...\n")
-			answer, err := s.peerConnection.CreateAnswer(nil)
-			if err != nil {
-				fmt.Printf("Error creating answer: %v\n", err)
-				continue
-			}
+// HandleOffer creates the viewer's video track in the codec picked from the
+// offer, viewers offering no video only get audio.
+func (p viewerPeer) HandleOffer(offerSDP string) error {
+	if p.VideoCodec() != "" {
+		return nil
+	}
 
-			fmt.Printf("Setting local description...\n")
-			if err := s.peerConnection.SetLocalDescription(answer); err != nil {
-				fmt.Printf("Error setting local description: %v\n", err)
-				continue
-			}
+	profileLevelID := p.sender.sourceProfileLevelID()
+	offered, err := offeredVideoCodecs(offerSDP, profileLevelID)
+	if err != nil || len(offered) == 0 {
+		return err
+	}
+	codec, err := p.sender.chooseVideoCodec(offered)
+	if err != nil {
+		return err
+	}
+	if err := p.addVideoTrack(codec, profileLevelID); err != nil {
+		return err
+	}
 
-			fmt.Printf("Sending answer to client (SDP length: %d)\n", len(answer.SDP))
-			if err := conn.WriteJSON(map[string]interface{}{
-				"type": "answer",
-				"sdp":  answer.SDP,
-			}); err != nil {
-				fmt.Printf("Error sending answer: %v\n", err)
-			}
+	fmt.Printf("Viewer %s receives %s video (offered %s)\n", p.id, codec, strings.Join(offered, ", "))
+	return nil
+}
 
-		case "ice-candidate":
-			candidateMap := message["candidate"].(map[string]interface{})
-			sdpMLineIndex := uint16(candidateMap["sdpMLineIndex"].(float64))
-			sdpMid := candidateMap["sdpMid"].(string)
+// HandleSignalingMessage handles the timeshift and live messages of a
+// viewer.
+func (p viewerPeer) HandleSignalingMessage(message signaling.Message) (*signaling.Message, error) {
+	switch message.Type {
+	case signaling.TypeTimeshift:
+		if p.sender.timeshifter == nil {
+			return nil, signaling.NewError(signaling.ErrorCodeInvalidMessage, "timeshift is not enabled")
+		}
+		if p.VideoCodec() != p.sender.sourceVideoCodec() {
+			// Only the live stream is transcoded
+			return nil, signaling.NewError(signaling.ErrorCodeInvalidMessage, "timeshift needs %s video", p.sender.sourceVideoCodec())
+		}
 
-			candidate := ext_webrtc.ICECandidateInit{
-				Candidate:     candidateMap["candidate"].(string),
-				SDPMLineIndex: &sdpMLineIndex,
-				SDPMid:        &sdpMid,
-			}
+		rate := message.Rate
+		if rate == 0 {
+			rate = 1
+		}
+		offset := time.Duration(message.Offset * float64(time.Second))
+		receivedAt, err := p.StartTimeshift(p.sender.timeshifter, time.Now().Add(-offset), rate)
+		if err != nil {
+			return nil, err
+		}
+		reply := signaling.TimeshiftMessage(time.Since(receivedAt), rate)
+		return &reply, nil
+
+	case signaling.TypeLive:
+		p.GoLive()
+		p.sender.primeViewer(p.viewer)
+		reply := signaling.LiveMessage()
+		return &reply, nil
+
+	case signaling.TypeTalkStart:
+		if p.sender.talkback == nil {
+			return nil, signaling.NewError(signaling.ErrorCodeInvalidMessage, "talkback is not enabled")
+		}
+		if err := p.sender.talkback.Acquire(p.id); err != nil {
+			return nil, signaling.NewError(signaling.ErrorCodeTalkBusy, "%v", err)
+		}
+		reply := signaling.TalkStartMessage()
+		return &reply, nil
 
-			if err := s.peerConnection.AddICECandidate(candidate); err != nil {
-				fmt.Printf("Error adding ICE candidate: %v\n", err)
-			}
+	case signaling.TypeTalkStop:
+		if p.sender.talkback != nil {
+			p.sender.talkback.Release(p.id)
 		}
+		reply := signaling.TalkStopMessage()
+		return &reply, nil
 	}
+
+	return nil, signaling.NewError(signaling.ErrorCodeUnknownType, "unexpected message type %q", message.Type)
+}
+
+func (s *WebRTCSender) newViewerPeer(req *http.Request) (signaling.Peer, error) {
+	v, err := s.addViewer(req.RemoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	return viewerPeer{viewer: v, sender: s}, nil
 }
 
 func (s *WebRTCSender) IsConnected() bool {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
-	return s.isConnected
+
+	for _, v := range s.viewers {
+		if v.IsConnected() {
+			return true
+		}
+	}
+	return false
 }
 
 func (s *WebRTCSender) Close() error {
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	close(s.stopChannel)
-
-	if s.peerConnection != nil {
-		return s.peerConnection.Close()
+	viewers := s.viewers
+	s.viewers = make(map[string]*viewer)
+	s.mutex.Unlock()
+
+	var closeErr error
+	for _, v := range viewers {
+		if err := v.Close(); err != nil {
+			closeErr = err
+		}
 	}
-	return nil
+	if s.talkback != nil {
+		s.talkback.Close()
+	}
+	return closeErr
 }
@@ -0,0 +1,296 @@
+package senders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"katkam/internal/config"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	ext_webrtc "github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+)
+
+const (
+	// A talker sending no audio for this long loses the floor to the next
+	// viewer asking for it
+	talkIdleTimeout = 5 * time.Second
+	// playerRestartDelay keeps a failing player from being restarted for
+	// every packet
+	playerRestartDelay = time.Second
+	// playerQueueSize holds a second of 20ms packets while the player is busy
+	playerQueueSize = 50
+)
+
+var ErrTalkerBusy = errors.New("another viewer is talking")
+
+// Talkback plays the microphone of one viewer at a time on the host's
+// speaker. A viewer takes the floor with push-to-talk and keeps it until it
+// lets go, leaves, stays silent for talkIdleTimeout or talked for
+// Config.MaxDuration. Audio of viewers without the floor is discarded.
+type Talkback struct {
+	Config config.Talkback
+
+	mutex       sync.Mutex
+	talker      string // Viewer id, empty while nobody talks
+	since       time.Time
+	lastPacket  time.Time
+	player      *talkPlayer
+	playerStart time.Time
+}
+
+func NewTalkback(cfg config.Talkback) (*Talkback, error) {
+	output, device := defaultTalkbackOutput()
+	if cfg.Output == "" {
+		cfg.Output = output
+	}
+	if cfg.Device == "" {
+		cfg.Device = device
+	}
+	if cfg.Device == "" {
+		cfg.Device = "default"
+	}
+	if len(cfg.Command) == 0 && cfg.Output == "" {
+		return nil, fmt.Errorf("no audio output available on this platform, configure a command")
+	}
+
+	return &Talkback{
+		Config: cfg,
+	}, nil
+}
+
+// Acquire gives viewer the floor, unless another viewer holds it.
+func (t *Talkback) Acquire(viewerID string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.talker != "" && t.talker != viewerID {
+		if !t.expiredLocked() {
+			return ErrTalkerBusy
+		}
+		t.releaseLocked()
+	}
+
+	if t.talker != viewerID {
+		t.talker = viewerID
+		t.since = time.Now()
+		t.lastPacket = t.since
+		fmt.Printf("🎙️ Viewer %s is talking\n", viewerID)
+	}
+	return nil
+}
+
+// Release takes the floor from viewer, if it holds it.
+func (t *Talkback) Release(viewerID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.talker == viewerID {
+		t.releaseLocked()
+	}
+}
+
+func (t *Talkback) releaseLocked() {
+	fmt.Printf("🔇 Viewer %s stopped talking\n", t.talker)
+	t.talker = ""
+	if t.player != nil {
+		t.player.Stop()
+		t.player = nil
+	}
+}
+
+// expiredLocked reports whether the talker lost its claim to the floor, must
+// be called with mutex held.
+func (t *Talkback) expiredLocked() bool {
+	return time.Since(t.lastPacket) > talkIdleTimeout ||
+		time.Since(t.since) > time.Duration(t.Config.MaxDuration)*time.Second
+}
+
+// receive reads the microphone track of a viewer until it ends, playing the
+// packets while the viewer holds the floor.
+func (t *Talkback) receive(viewerID string, track *ext_webrtc.TrackRemote) {
+	if track.Codec().MimeType != ext_webrtc.MimeTypeOpus {
+		fmt.Printf("Ignoring %s track of viewer %s\n", track.Codec().MimeType, viewerID)
+		return
+	}
+
+	defer t.Release(viewerID)
+	for {
+		packet, _, err := track.ReadRTP()
+		if err != nil {
+			return
+		}
+		t.write(viewerID, packet)
+	}
+}
+
+func (t *Talkback) write(viewerID string, packet *rtp.Packet) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.talker != viewerID {
+		return
+	}
+	if time.Since(t.since) > time.Duration(t.Config.MaxDuration)*time.Second {
+		fmt.Printf("⏱️ Viewer %s talked for %d seconds\n", viewerID, t.Config.MaxDuration)
+		t.releaseLocked()
+		return
+	}
+	t.lastPacket = time.Now()
+
+	if t.player != nil && t.player.exited() {
+		t.player.Stop()
+		t.player = nil
+	}
+	if t.player == nil {
+		if time.Since(t.playerStart) < playerRestartDelay {
+			return
+		}
+		t.playerStart = time.Now()
+		t.player = startTalkPlayer(t.command())
+	}
+
+	t.player.WriteRTP(packet)
+}
+
+// command returns the player command, reading Ogg/Opus on stdin.
+func (t *Talkback) command() []string {
+	if len(t.Config.Command) > 0 {
+		return t.Config.Command
+	}
+
+	return []string{
+		"ffmpeg",
+		"-loglevel", "error",
+		// Play packets as they come instead of buffering
+		"-fflags", "nobuffer",
+		"-flags", "low_delay",
+		"-probesize", "32",
+		"-analyzeduration", "0",
+		"-f", "ogg",
+		"-i", "pipe:0",
+		"-f", t.Config.Output,
+		t.Config.Device,
+	}
+}
+
+// Status reports who is talking in the status API.
+func (t *Talkback) Status() map[string]interface{} {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return map[string]interface{}{
+		"talkback": map[string]interface{}{
+			"talker": t.talker,
+		},
+	}
+}
+
+// Close stops the playback.
+func (t *Talkback) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.talker != "" {
+		t.releaseLocked()
+	}
+	return nil
+}
+
+// talkPlayer is a player process fed with Ogg/Opus pages by its own
+// goroutine, so a stalled audio output never blocks the Talkback.
+type talkPlayer struct {
+	command []string
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	packets  chan *rtp.Packet
+	stopOnce sync.Once
+}
+
+// startTalkPlayer starts the player in the background, failures to start it
+// are logged and make it exit.
+func startTalkPlayer(command []string) *talkPlayer {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &talkPlayer{
+		command: command,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		packets: make(chan *rtp.Packet, playerQueueSize),
+	}
+	go p.run(ctx)
+	return p
+}
+
+func (p *talkPlayer) run(ctx context.Context) {
+	defer close(p.done)
+	defer p.cancel()
+
+	cmd := exec.CommandContext(ctx, p.command[0], p.command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		fmt.Printf("❌ Failed to create talkback stdin pipe: %v\n", err)
+		return
+	}
+
+	fmt.Printf("🔊 Starting talkback player: %s\n", cmd.String())
+	if err := cmd.Start(); err != nil {
+		fmt.Printf("❌ Failed to start talkback player: %v\n", err)
+		return
+	}
+
+	if err := p.feed(stdin); err != nil {
+		fmt.Printf("❌ Talkback player failed: %v\n", err)
+		p.cancel()
+	}
+	// Stdin is closed, the player exits once it played what it buffered
+	cmd.Wait()
+}
+
+// feed writes the queued packets to the player until Stop.
+func (p *talkPlayer) feed(stdin io.WriteCloser) error {
+	defer stdin.Close()
+
+	ogg, err := oggwriter.NewWith(stdin, 48000, 2)
+	if err != nil {
+		return fmt.Errorf("failed to write Ogg header: %v", err)
+	}
+	for packet := range p.packets {
+		if err := ogg.WriteRTP(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteRTP queues a packet, dropping it when the player cannot keep up.
+func (p *talkPlayer) WriteRTP(packet *rtp.Packet) {
+	select {
+	case p.packets <- packet:
+	default:
+	}
+}
+
+// exited reports whether the player failed or exited.
+func (p *talkPlayer) exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop lets the player finish the queued packets and exit, and kills it if
+// it does not exit by itself or stalls. Must not be called concurrently with
+// WriteRTP, the Talkback's mutex orders them.
+func (p *talkPlayer) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.packets)
+		time.AfterFunc(2*time.Second, p.cancel)
+	})
+}
@@ -0,0 +1,8 @@
+//go:build darwin
+
+package senders
+
+func defaultTalkbackOutput() (output, device string) {
+	// audiotoolbox ignores the output name and plays on the default device
+	return "audiotoolbox", "default"
+}
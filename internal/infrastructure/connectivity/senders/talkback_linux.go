@@ -0,0 +1,14 @@
+//go:build linux
+
+package senders
+
+import "os"
+
+func defaultTalkbackOutput() (output, device string) {
+	// Prefer PulseAudio (or PipeWire's pulse shim) when a server is reachable,
+	// so the speaker can be shared with other applications.
+	if os.Getenv("PULSE_SERVER") != "" {
+		return "pulse", "default"
+	}
+	return "alsa", "default"
+}
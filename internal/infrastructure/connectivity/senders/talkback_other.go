@@ -0,0 +1,7 @@
+//go:build !darwin && !linux
+
+package senders
+
+func defaultTalkbackOutput() (output, device string) {
+	return "", ""
+}
@@ -0,0 +1,208 @@
+package senders
+
+import (
+	"errors"
+	"fmt"
+	"katkam/internal/infrastructure/connectivity"
+	"time"
+)
+
+const (
+	// playbackBatch is how many buffered frames are read at once
+	playbackBatch = 64
+	// playbackPoll is how long playback waits for new frames once it reached
+	// the head of the buffer
+	playbackPoll = 20 * time.Millisecond
+	// maxPlaybackGap is the longest pause in the buffer played back as is,
+	// longer ones (the source was gone) are skipped
+	maxPlaybackGap = 2 * time.Second
+)
+
+var ErrNothingBuffered = errors.New("nothing is buffered yet")
+
+// playback replaces the live stream of a viewer with frames from the DVR
+// buffer, paced by the time they were received.
+type playback struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartTimeshift plays the buffered stream to the viewer starting at the
+// keyframe nearest to at. With rate above 1 the video is played faster, and
+// without audio, until it caught up with the live stream, the viewer then
+// continues live. It returns the receive time of the starting keyframe.
+func (v *viewer) StartTimeshift(timeshifter connectivity.Timeshifter, at time.Time, rate float64) (time.Time, error) {
+	v.timeshiftMutex.Lock()
+	defer v.timeshiftMutex.Unlock()
+
+	sequence, receivedAt, ok := timeshifter.Seek(at)
+	if !ok {
+		return time.Time{}, ErrNothingBuffered
+	}
+
+	v.stopPlayback()
+	v.timeshifted.Store(true)
+	v.drainQueues()
+
+	p := &playback{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	v.playback = p
+	go v.play(p, timeshifter, sequence, rate)
+
+	fmt.Printf("⏪ Viewer %s watching %.1fs behind live at %.1fx\n", v.id, time.Since(receivedAt).Seconds(), rate)
+	return receivedAt, nil
+}
+
+// GoLive stops a timeshifted playback, the viewer continues with the next
+// live keyframe, or the cached group of pictures when primed.
+func (v *viewer) GoLive() {
+	v.timeshiftMutex.Lock()
+	defer v.timeshiftMutex.Unlock()
+
+	v.stopPlayback()
+	if !v.timeshifted.Load() {
+		return
+	}
+	v.drainQueues()
+	v.awaitingKeyframe.Store(true)
+	v.timeshifted.Store(false)
+	fmt.Printf("▶️ Viewer %s back to live\n", v.id)
+}
+
+// stopPlayback waits for the running playback to finish, must be called with
+// timeshiftMutex held.
+func (v *viewer) stopPlayback() {
+	if v.playback == nil {
+		return
+	}
+	close(v.playback.stop)
+	<-v.playback.done
+	v.playback = nil
+}
+
+// drainQueues drops the queued frames, which belong to the stream the viewer
+// is leaving.
+func (v *viewer) drainQueues() {
+	for {
+		select {
+		case <-v.videoChannel:
+		case <-v.audioChannel:
+		default:
+			return
+		}
+	}
+}
+
+func (v *viewer) play(p *playback, timeshifter connectivity.Timeshifter, sequence uint64, rate float64) {
+	defer close(p.done)
+
+	// Frames of another codec were buffered before the source switched
+	codec := v.VideoCodec()
+
+	var (
+		started     bool
+		origin      time.Time // Receive time played at clock
+		clock       time.Time
+		lastArrival time.Time
+		lastPTS     time.Duration
+		pts         time.Duration
+	)
+
+	for {
+		frames, ok := timeshifter.ReadFrames(sequence, playbackBatch)
+		if !ok {
+			// Fell out of the buffer, continue with its oldest keyframe
+			sequence, _, ok = timeshifter.Seek(time.Time{})
+			if !ok {
+				v.catchUp()
+				return
+			}
+			started = false
+			continue
+		}
+
+		if len(frames) == 0 {
+			if rate > 1 {
+				v.catchUp()
+				return
+			}
+			select {
+			case <-p.stop:
+				return
+			case <-v.stopChannel:
+				return
+			case <-time.After(playbackPoll):
+			}
+			continue
+		}
+
+		for _, frame := range frames {
+			sequence = frame.Sequence + 1
+			if frame.Video && frame.Codec != codec {
+				continue
+			}
+
+			switch {
+			case !started:
+				if !frame.Video || !frame.Keyframe {
+					continue
+				}
+				started = true
+				origin, clock = frame.ReceivedAt, time.Now()
+				pts, lastPTS = frame.PTS, frame.PTS
+			case frame.ReceivedAt.Sub(lastArrival) > maxPlaybackGap:
+				origin = origin.Add(frame.ReceivedAt.Sub(lastArrival))
+			}
+			lastArrival = frame.ReceivedAt
+
+			due := clock.Add(time.Duration(float64(frame.ReceivedAt.Sub(origin)) / rate))
+			if wait := time.Until(due); wait > 0 {
+				select {
+				case <-p.stop:
+					return
+				case <-v.stopChannel:
+					return
+				case <-time.After(wait):
+				}
+			}
+
+			if rate != 1 {
+				// Audio cannot be played faster, and the video needs a
+				// timeline matching its pace
+				if !frame.Video {
+					continue
+				}
+				if delta := frame.PTS - lastPTS; delta >= 0 && delta <= maxTimestampJump {
+					pts += time.Duration(float64(delta) / rate)
+				} else {
+					pts += defaultFrameStep
+				}
+				lastPTS = frame.PTS
+				frame.PTS = pts
+			}
+
+			queue := v.audioChannel
+			if frame.Video {
+				queue = v.videoChannel
+			}
+			select {
+			case <-p.stop:
+				return
+			case <-v.stopChannel:
+				return
+			case queue <- frame.Frame:
+			}
+		}
+	}
+}
+
+// catchUp switches a playback that reached the live stream over to it, the
+// viewer waits for the next keyframe.
+func (v *viewer) catchUp() {
+	v.awaitingKeyframe.Store(true)
+	v.timeshifted.Store(false)
+	go v.requestKeyframe()
+	fmt.Printf("▶️ Viewer %s caught up with live\n", v.id)
+}
@@ -0,0 +1,100 @@
+package senders
+
+import (
+	"katkam/internal/infrastructure/connectivity"
+	"math/rand"
+	"time"
+
+	"github.com/pion/rtp"
+	ext_webrtc "github.com/pion/webrtc/v3"
+)
+
+const (
+	rtpMTU = 1200
+
+	// maxTimestampJump is the largest forward PTS jump written as is, bigger
+	// jumps (and any jump backwards) are treated as a restarted source
+	maxTimestampJump = 5 * time.Second
+	defaultFrameStep = 33 * time.Millisecond
+)
+
+// trackWriter packetizes frames onto a local RTP track. RTP timestamps are
+// derived from each frame's PTS instead of nominal frame durations, so dropped
+// or late frames do not make playback drift.
+type trackWriter struct {
+	track           *ext_webrtc.TrackLocalStaticRTP
+	payloader       rtp.Payloader
+	sequencer       rtp.Sequencer
+	clockRate       uint32
+	markLastPacket  bool
+	timestampOffset uint32
+
+	started      bool
+	rebase       time.Duration
+	lastPTS      time.Duration
+	lastDuration time.Duration
+}
+
+func newTrackWriter(track *ext_webrtc.TrackLocalStaticRTP, payloader rtp.Payloader, clockRate uint32, markLastPacket bool) *trackWriter {
+	return &trackWriter{
+		track:           track,
+		payloader:       payloader,
+		sequencer:       rtp.NewRandomSequencer(),
+		clockRate:       clockRate,
+		markLastPacket:  markLastPacket,
+		timestampOffset: rand.Uint32(),
+	}
+}
+
+func (w *trackWriter) WriteFrame(frame connectivity.Frame) error {
+	pts := w.timelinePTS(frame)
+	timestamp := w.timestampOffset + w.ticks(pts)
+
+	payloads := w.payloader.Payload(rtpMTU, frame.Data)
+	for i, payload := range payloads {
+		packet := &rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         w.markLastPacket && i == len(payloads)-1,
+				SequenceNumber: w.sequencer.NextSequenceNumber(),
+				Timestamp:      timestamp,
+			},
+			Payload: payload,
+		}
+		if err := w.track.WriteRTP(packet); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ticks converts a PTS to clock rate units, wrapping like RTP timestamps do.
+func (w *trackWriter) ticks(pts time.Duration) uint32 {
+	seconds, remainder := uint64(pts/time.Second), uint64(pts%time.Second)
+	return uint32(seconds*uint64(w.clockRate) + remainder*uint64(w.clockRate)/uint64(time.Second))
+}
+
+// timelinePTS maps the frame PTS onto the continuous timeline of the track,
+// rebasing when the source restarts or jumps.
+func (w *trackWriter) timelinePTS(frame connectivity.Frame) time.Duration {
+	pts := frame.PTS + w.rebase
+	if w.started && (pts < w.lastPTS || pts-w.lastPTS > maxTimestampJump) {
+		step := w.lastDuration
+		if step <= 0 {
+			step = defaultFrameStep
+		}
+		w.rebase = w.lastPTS + step - frame.PTS
+		pts = w.lastPTS + step
+	}
+
+	if w.started && pts > w.lastPTS {
+		w.lastDuration = pts - w.lastPTS
+	} else if frame.Duration > 0 {
+		w.lastDuration = frame.Duration
+	}
+	w.started = true
+	w.lastPTS = pts
+
+	return pts
+}
@@ -0,0 +1,311 @@
+package senders
+
+import (
+	"fmt"
+	"katkam/internal/infrastructure/connectivity"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp/codecs"
+	ext_webrtc "github.com/pion/webrtc/v3"
+)
+
+// viewerQueueSize holds a full cached group of pictures plus some slack
+const viewerQueueSize = 400
+
+// viewer is a single watching client with its own PeerConnection, tracks and
+// frame queues, so a slow or failing viewer never affects the others.
+type viewer struct {
+	id             string
+	remoteAddr     string
+	createdAt      time.Time
+	peerConnection *ext_webrtc.PeerConnection
+	videoTrack     *trackWriter
+	audioTrack     *trackWriter
+
+	videoChannel chan connectivity.Frame
+	audioChannel chan connectivity.Frame
+	stopChannel  chan struct{}
+	closeOnce    sync.Once
+
+	mutex       sync.RWMutex
+	state       ext_webrtc.PeerConnectionState
+	connectedAt time.Time
+	videoCodec  string // Negotiated from the offer, empty until then
+
+	// A viewer only receives video once it got a keyframe to decode from
+	awaitingKeyframe atomic.Bool
+	requestKeyframe  func()
+
+	// While timeshifted the live stream is ignored, playback feeds the
+	// queues from the DVR buffer instead
+	timeshifted    atomic.Bool
+	timeshiftMutex sync.Mutex
+	playback       *playback
+
+	videoFramesSent    atomic.Uint64
+	videoFramesDropped atomic.Uint64
+	audioFramesSent    atomic.Uint64
+	audioFramesDropped atomic.Uint64
+}
+
+// newViewer creates the viewer's PeerConnection, receiving the viewer's
+// microphone only when talkback is enabled.
+func newViewer(remoteAddr string, talkback bool, onStateChange func(*viewer, ext_webrtc.PeerConnectionState), requestKeyframe func()) (*viewer, error) {
+	config := ext_webrtc.Configuration{
+		ICEServers: []ext_webrtc.ICEServer{
+			{
+				URLs: []string{
+					"stun:stun.l.google.com:19302",
+					"stun:stun1.l.google.com:19302",
+				},
+			},
+		},
+		ICECandidatePoolSize: 10,
+	}
+
+	pc, err := ext_webrtc.NewPeerConnection(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create peer connection: %v", err)
+	}
+
+	v := &viewer{
+		id:              uuid.NewString(),
+		remoteAddr:      remoteAddr,
+		createdAt:       time.Now(),
+		peerConnection:  pc,
+		videoChannel:    make(chan connectivity.Frame, viewerQueueSize),
+		audioChannel:    make(chan connectivity.Frame, viewerQueueSize),
+		stopChannel:     make(chan struct{}),
+		state:           ext_webrtc.PeerConnectionStateNew,
+		requestKeyframe: requestKeyframe,
+	}
+	v.awaitingKeyframe.Store(true)
+
+	if err := v.addTracks(talkback); err != nil {
+		pc.Close()
+		return nil, err
+	}
+
+	// Handle connection state changes
+	pc.OnConnectionStateChange(func(state ext_webrtc.PeerConnectionState) {
+		fmt.Printf("Viewer %s connection state: %s\n", v.id, state.String())
+		v.mutex.Lock()
+		v.state = state
+		if state == ext_webrtc.PeerConnectionStateConnected && v.connectedAt.IsZero() {
+			v.connectedAt = time.Now()
+		}
+		v.mutex.Unlock()
+
+		if onStateChange != nil {
+			onStateChange(v, state)
+		}
+	})
+
+	// Handle ICE connection state changes
+	pc.OnICEConnectionStateChange(func(state ext_webrtc.ICEConnectionState) {
+		fmt.Printf("Viewer %s ICE connection state: %s\n", v.id, state.String())
+	})
+
+	go v.stream()
+
+	return v, nil
+}
+
+// addTracks adds the audio track, the video track is added once its codec
+// was negotiated.
+func (v *viewer) addTracks(talkback bool) error {
+	// Create audio track
+	audioTrack, err := ext_webrtc.NewTrackLocalStaticRTP(
+		ext_webrtc.RTPCodecCapability{MimeType: ext_webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2},
+		"audio",
+		"relay-audio",
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create audio track: %v", err)
+	}
+	v.audioTrack = newTrackWriter(audioTrack, &codecs.OpusPayloader{}, 48000, false)
+
+	// Receive as well only when viewers can talk back, otherwise the answer
+	// would invite audio nobody plays
+	direction := ext_webrtc.RTPTransceiverDirectionSendonly
+	if talkback {
+		direction = ext_webrtc.RTPTransceiverDirectionSendrecv
+	}
+	_, err = v.peerConnection.AddTransceiverFromTrack(audioTrack, ext_webrtc.RTPTransceiverInit{
+		Direction: direction,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add audio track: %v", err)
+	}
+
+	return nil
+}
+
+// addVideoTrack adds the video track in codec, H.264 in the profile of
+// profileLevelID, it must be called before the offer is answered. A viewer keeps its codec when renegotiating.
+func (v *viewer) addVideoTrack(codec, profileLevelID string) error {
+	if v.VideoCodec() != "" {
+		return nil
+	}
+
+	capability, payloader, err := videoCodecCapability(codec, profileLevelID)
+	if err != nil {
+		return err
+	}
+	videoTrack, err := ext_webrtc.NewTrackLocalStaticRTP(capability, "video", "relay-video")
+	if err != nil {
+		return fmt.Errorf("failed to create video track: %v", err)
+	}
+	v.videoTrack = newTrackWriter(videoTrack, payloader, 90000, true)
+
+	videoSender, err := v.peerConnection.AddTrack(videoTrack)
+	if err != nil {
+		return fmt.Errorf("failed to add video track: %v", err)
+	}
+	go v.readVideoRTCP(videoSender)
+
+	v.mutex.Lock()
+	v.videoCodec = codec
+	v.mutex.Unlock()
+	return nil
+}
+
+// VideoCodec returns the codec of the viewer's video track, frames in other
+// codecs must not be sent to it.
+func (v *viewer) VideoCodec() string {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	return v.videoCodec
+}
+
+// readVideoRTCP forwards the viewer's keyframe requests to the source. Reading
+// RTCP is also what lets the NACK interceptors retransmit lost packets.
+func (v *viewer) readVideoRTCP(sender *ext_webrtc.RTPSender) {
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+
+		for _, packet := range packets {
+			switch packet.(type) {
+			case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+				v.requestKeyframe()
+			}
+		}
+	}
+}
+
+func (v *viewer) stream() {
+	for {
+		select {
+		case <-v.stopChannel:
+			fmt.Printf("🛑 Viewer %s streaming stopped. Total frames sent: %d\n", v.id, v.videoFramesSent.Load())
+			return
+		case frame := <-v.videoChannel:
+			if err := v.videoTrack.WriteFrame(frame); err != nil {
+				fmt.Printf("❌ Error writing video sample to viewer %s: %v\n", v.id, err)
+				continue
+			}
+			v.videoFramesSent.Add(1)
+		case frame := <-v.audioChannel:
+			if err := v.audioTrack.WriteFrame(frame); err != nil {
+				fmt.Printf("Error writing audio sample to viewer %s: %v\n", v.id, err)
+				continue
+			}
+			v.audioFramesSent.Add(1)
+		}
+	}
+}
+
+func (v *viewer) SendVideoFrame(frame connectivity.Frame) {
+	if v.timeshifted.Load() {
+		return
+	}
+	if v.awaitingKeyframe.Load() {
+		if !frame.Keyframe {
+			return
+		}
+		v.awaitingKeyframe.Store(false)
+	}
+
+	select {
+	case v.videoChannel <- frame:
+	default:
+		// Viewer cannot keep up, drop frame and restart from a keyframe as
+		// the following frames reference the dropped one
+		v.videoFramesDropped.Add(1)
+		v.awaitingKeyframe.Store(true)
+		go v.requestKeyframe()
+	}
+}
+
+// Prime starts a viewer that is still waiting for a keyframe on the cached
+// group of pictures, instead of waiting for the next keyframe.
+func (v *viewer) Prime(frames []connectivity.Frame) bool {
+	if !v.awaitingKeyframe.Load() || len(frames) == 0 || !frames[0].Keyframe || frames[0].Codec != v.VideoCodec() {
+		return false
+	}
+	if len(frames) > cap(v.videoChannel)-len(v.videoChannel) {
+		return false
+	}
+
+	for _, frame := range frames {
+		v.videoChannel <- frame
+	}
+	v.awaitingKeyframe.Store(false)
+	return true
+}
+
+func (v *viewer) SendAudioFrame(frame connectivity.Frame) {
+	if v.timeshifted.Load() {
+		return
+	}
+	select {
+	case v.audioChannel <- frame:
+	default:
+		v.audioFramesDropped.Add(1)
+	}
+}
+
+func (v *viewer) PeerConnection() *ext_webrtc.PeerConnection {
+	return v.peerConnection
+}
+
+func (v *viewer) IsConnected() bool {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	return v.state == ext_webrtc.PeerConnectionStateConnected
+}
+
+func (v *viewer) Stats() connectivity.ViewerStats {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+
+	return connectivity.ViewerStats{
+		ID:                 v.id,
+		RemoteAddr:         v.remoteAddr,
+		State:              v.state.String(),
+		CreatedAt:          v.createdAt,
+		ConnectedAt:        v.connectedAt,
+		VideoFramesSent:    v.videoFramesSent.Load(),
+		VideoFramesDropped: v.videoFramesDropped.Load(),
+		AudioFramesSent:    v.audioFramesSent.Load(),
+		AudioFramesDropped: v.audioFramesDropped.Load(),
+		Timeshifted:        v.timeshifted.Load(),
+		VideoCodec:         v.videoCodec,
+	}
+}
+
+func (v *viewer) Close() error {
+	var err error
+	v.closeOnce.Do(func() {
+		close(v.stopChannel)
+		err = v.peerConnection.Close()
+	})
+	return err
+}
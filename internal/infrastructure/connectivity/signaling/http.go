@@ -0,0 +1,267 @@
+package signaling
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	ext_webrtc "github.com/pion/webrtc/v3"
+)
+
+const (
+	ContentTypeSDP        = "application/sdp"
+	ContentTypeTrickleICE = "application/trickle-ice-sdpfrag"
+	gatheringTimeout      = 5 * time.Second
+	resourceSweepInterval = 10 * time.Second
+	maxSDPSize            = 64 * 1024
+)
+
+// HTTPServer implements the HTTP offer/answer exchange shared by WHIP and
+// WHEP. A POST with an SDP offer creates a session resource and is answered
+// with 201 Created, the answer SDP and the resource URL in Location. PATCH on
+// the resource adds trickled ICE candidates and DELETE ends the session.
+//
+// Answers are only sent once ICE gathering completed, so clients that do not
+// trickle still learn all candidates of the server.
+type HTTPServer struct {
+	name      string
+	mutex     sync.Mutex
+	resources map[string]Peer
+}
+
+func NewHTTPServer(name string) *HTTPServer {
+	s := &HTTPServer{
+		name:      name,
+		resources: make(map[string]Peer),
+	}
+	go s.sweep()
+	return s
+}
+
+// Serve handles requests on the endpoint URL and on the session resources
+// below it, which end in the resource id.
+func (s *HTTPServer) Serve(w http.ResponseWriter, req *http.Request, newPeer PeerFactory) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, PATCH, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match")
+	w.Header().Set("Access-Control-Expose-Headers", "Location, Link")
+
+	switch req.Method {
+	case http.MethodOptions:
+		w.Header().Set("Accept-Post", ContentTypeSDP)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		s.createSession(w, req, newPeer)
+	case http.MethodPatch:
+		s.trickle(w, req)
+	case http.MethodDelete:
+		s.deleteSession(w, req)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *HTTPServer) createSession(w http.ResponseWriter, req *http.Request, newPeer PeerFactory) {
+	if !hasContentType(req, ContentTypeSDP) {
+		http.Error(w, "Offer must be "+ContentTypeSDP, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offer, err := io.ReadAll(io.LimitReader(req.Body, maxSDPSize))
+	if err != nil || len(offer) == 0 {
+		http.Error(w, "Missing SDP offer", http.StatusBadRequest)
+		return
+	}
+
+	peer, err := newPeer(req)
+	if err != nil {
+		fmt.Printf("Failed to initialize %s peer connection: %v\n", s.name, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), gatheringTimeout)
+	defer cancel()
+
+	err = prepareOffer(peer, string(offer))
+	answer := ""
+	if err == nil {
+		answer, err = AnswerGathered(ctx, peer.PeerConnection(), string(offer))
+	}
+	if err != nil {
+		fmt.Printf("Error negotiating %s session with %s: %v\n", s.name, req.RemoteAddr, err)
+		peer.Close()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := uuid.NewString()
+	s.mutex.Lock()
+	s.resources[id] = peer
+	s.mutex.Unlock()
+
+	fmt.Printf("%s session %s created for %s\n", s.name, id, req.RemoteAddr)
+
+	w.Header().Set("Content-Type", ContentTypeSDP)
+	w.Header().Set("Location", strings.TrimSuffix(req.URL.Path, "/")+"/"+id)
+	w.WriteHeader(http.StatusCreated)
+	io.WriteString(w, answer)
+}
+
+func (s *HTTPServer) trickle(w http.ResponseWriter, req *http.Request) {
+	peer, ok := s.resource(req)
+	if !ok {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	if !hasContentType(req, ContentTypeTrickleICE) {
+		http.Error(w, "Candidates must be "+ContentTypeTrickleICE, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	fragment, err := io.ReadAll(io.LimitReader(req.Body, maxSDPSize))
+	if err != nil {
+		http.Error(w, "Failed to read candidates", http.StatusBadRequest)
+		return
+	}
+
+	pc := peer.PeerConnection()
+	candidates, ufrag := ParseTrickleFragment(string(fragment))
+	if ufrag != "" && pc.RemoteDescription() != nil && !strings.Contains(pc.RemoteDescription().SDP, "a=ice-ufrag:"+ufrag) {
+		// ICE restarts would need a new answer, which is not supported
+		http.Error(w, "ICE restart is not supported", http.StatusUnprocessableEntity)
+		return
+	}
+
+	for _, candidate := range candidates {
+		if err := pc.AddICECandidate(candidate); err != nil {
+			fmt.Printf("Error adding %s ICE candidate: %v\n", s.name, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *HTTPServer) deleteSession(w http.ResponseWriter, req *http.Request) {
+	id := path.Base(req.URL.Path)
+
+	s.mutex.Lock()
+	peer, ok := s.resources[id]
+	delete(s.resources, id)
+	s.mutex.Unlock()
+
+	if !ok {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	fmt.Printf("%s session %s deleted\n", s.name, id)
+	if err := peer.Close(); err != nil {
+		fmt.Printf("Error closing %s session %s: %v\n", s.name, id, err)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *HTTPServer) resource(req *http.Request) (Peer, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	peer, ok := s.resources[path.Base(req.URL.Path)]
+	return peer, ok
+}
+
+// sweep forgets sessions whose PeerConnection failed or was closed without the
+// client deleting its resource.
+func (s *HTTPServer) sweep() {
+	ticker := time.NewTicker(resourceSweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var ended []Peer
+
+		s.mutex.Lock()
+		for id, peer := range s.resources {
+			switch peer.PeerConnection().ConnectionState() {
+			case ext_webrtc.PeerConnectionStateFailed, ext_webrtc.PeerConnectionStateClosed:
+				fmt.Printf("%s session %s ended\n", s.name, id)
+				delete(s.resources, id)
+				ended = append(ended, peer)
+			}
+		}
+		s.mutex.Unlock()
+
+		for _, peer := range ended {
+			peer.Close()
+		}
+	}
+}
+
+// ParseTrickleFragment extracts the ICE candidates and the ICE username
+// fragment of a trickle-ice-sdpfrag body (RFC 8840). A username fragment
+// other than the one of the offer means the client restarts ICE.
+func ParseTrickleFragment(fragment string) (candidates []ext_webrtc.ICECandidateInit, ufrag string) {
+	var mid *string
+	var lineIndex uint16
+	sections := 0
+
+	for _, line := range strings.Split(fragment, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "m="):
+			lineIndex = uint16(sections)
+			sections++
+			mid = nil
+		case strings.HasPrefix(line, "a=mid:"):
+			value := strings.TrimPrefix(line, "a=mid:")
+			mid = &value
+		case strings.HasPrefix(line, "a=ice-ufrag:"):
+			ufrag = strings.TrimPrefix(line, "a=ice-ufrag:")
+		case strings.HasPrefix(line, "a=candidate:"):
+			candidate := ext_webrtc.ICECandidateInit{
+				Candidate: strings.TrimPrefix(line, "a="),
+				SDPMid:    mid,
+			}
+			if mid == nil {
+				index := lineIndex
+				candidate.SDPMLineIndex = &index
+			}
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	return candidates, ufrag
+}
+
+// AnswerGathered applies a remote offer to pc and returns the local answer
+// including all gathered ICE candidates.
+func AnswerGathered(ctx context.Context, pc *ext_webrtc.PeerConnection, offerSDP string) (string, error) {
+	gathered := ext_webrtc.GatheringCompletePromise(pc)
+
+	if _, err := Answer(pc, offerSDP); err != nil {
+		return "", err
+	}
+
+	select {
+	case <-gathered:
+	case <-ctx.Done():
+		// Answer with what has been gathered, the rest can still be found
+		// through peer reflexive candidates
+		fmt.Printf("ICE gathering incomplete: %v\n", ctx.Err())
+	}
+
+	return pc.LocalDescription().SDP, nil
+}
+
+func hasContentType(req *http.Request, contentType string) bool {
+	value, _, _ := strings.Cut(req.Header.Get("Content-Type"), ";")
+	return strings.EqualFold(strings.TrimSpace(value), contentType)
+}
@@ -0,0 +1,111 @@
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	ext_webrtc "github.com/pion/webrtc/v3"
+)
+
+// Decode parses and validates a message received from a client. The returned
+// error is always an *Error that can be sent back with ErrorMessage.
+func Decode(data []byte) (Message, error) {
+	var message Message
+	if err := json.Unmarshal(data, &message); err != nil {
+		return Message{}, NewError(ErrorCodeInvalidMessage, "malformed message: %v", err)
+	}
+
+	if err := message.Validate(); err != nil {
+		return Message{}, err
+	}
+	return message, nil
+}
+
+func (m *Message) Validate() error {
+	// Clients predating versioning speak version 1
+	if m.Version == 0 {
+		m.Version = 1
+	}
+	if m.Version < 0 || m.Version > ProtocolVersion {
+		return NewError(ErrorCodeUnsupportedVersion, "protocol version %d is not supported, server speaks version %d", m.Version, ProtocolVersion)
+	}
+
+	switch m.Type {
+	case TypeOffer, TypeAnswer:
+		if m.SDP == "" {
+			return NewError(ErrorCodeInvalidMessage, "%s is missing sdp", m.Type)
+		}
+	case TypeICECandidate:
+		if m.Candidate == nil {
+			return NewError(ErrorCodeInvalidCandidate, "ice-candidate is missing candidate")
+		}
+		if m.Candidate.SDPMid == nil && m.Candidate.SDPMLineIndex == nil {
+			return NewError(ErrorCodeInvalidCandidate, "candidate needs sdpMid or sdpMLineIndex")
+		}
+	case TypeError:
+		if m.Error == nil {
+			return NewError(ErrorCodeInvalidMessage, "error is missing error")
+		}
+	case TypeTimeshift:
+		if m.Offset <= 0 {
+			return NewError(ErrorCodeInvalidMessage, "timeshift needs a positive offset")
+		}
+		if m.Rate != 0 && (m.Rate < 1 || m.Rate > MaxTimeshiftRate) {
+			return NewError(ErrorCodeInvalidMessage, "rate must be between 1 and %d", MaxTimeshiftRate)
+		}
+	case TypeBye, TypePing, TypePong, TypeLive, TypeTalkStart, TypeTalkStop:
+	case "":
+		return NewError(ErrorCodeInvalidMessage, "message is missing type")
+	default:
+		return NewError(ErrorCodeUnknownType, "unknown message type %q", m.Type)
+	}
+
+	return nil
+}
+
+func OfferMessage(sdp string) Message {
+	return Message{Version: ProtocolVersion, Type: TypeOffer, SDP: sdp}
+}
+
+func AnswerMessage(sdp string) Message {
+	return Message{Version: ProtocolVersion, Type: TypeAnswer, SDP: sdp}
+}
+
+func ICECandidateMessage(candidate ext_webrtc.ICECandidateInit) Message {
+	return Message{Version: ProtocolVersion, Type: TypeICECandidate, Candidate: &candidate}
+}
+
+func PongMessage() Message {
+	return Message{Version: ProtocolVersion, Type: TypePong}
+}
+
+func ByeMessage() Message {
+	return Message{Version: ProtocolVersion, Type: TypeBye}
+}
+
+func TimeshiftMessage(offset time.Duration, rate float64) Message {
+	return Message{Version: ProtocolVersion, Type: TypeTimeshift, Offset: offset.Seconds(), Rate: rate}
+}
+
+func LiveMessage() Message {
+	return Message{Version: ProtocolVersion, Type: TypeLive}
+}
+
+func TalkStartMessage() Message {
+	return Message{Version: ProtocolVersion, Type: TypeTalkStart}
+}
+
+func TalkStopMessage() Message {
+	return Message{Version: ProtocolVersion, Type: TypeTalkStop}
+}
+
+// ErrorMessage wraps err in an error message, errors that are not an *Error
+// are reported with the given fallback code.
+func ErrorMessage(fallbackCode string, err error) Message {
+	var signalingErr *Error
+	if !errors.As(err, &signalingErr) {
+		signalingErr = &Error{Code: fallbackCode, Message: err.Error()}
+	}
+	return Message{Version: ProtocolVersion, Type: TypeError, Error: signalingErr}
+}
@@ -0,0 +1,222 @@
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	ext_webrtc "github.com/pion/webrtc/v3"
+)
+
+// invalidMessages are messages clients must get an error reply for, with the
+// code of the reply.
+var invalidMessages = []struct {
+	name    string
+	message string
+	code    string
+}{
+	{"not JSON", `offer`, ErrorCodeInvalidMessage},
+	{"truncated", `{"type":"offer","sdp":"v=0`, ErrorCodeInvalidMessage},
+	{"not an object", `["offer"]`, ErrorCodeInvalidMessage},
+	{"wrong field type", `{"type":"offer","sdp":42}`, ErrorCodeInvalidMessage},
+	{"missing type", `{"version":1}`, ErrorCodeInvalidMessage},
+	{"unknown type", `{"version":1,"type":"subscribe"}`, ErrorCodeUnknownType},
+	{"future version", `{"version":2,"type":"ping"}`, ErrorCodeUnsupportedVersion},
+	{"negative version", `{"version":-1,"type":"ping"}`, ErrorCodeUnsupportedVersion},
+	{"offer without sdp", `{"version":1,"type":"offer"}`, ErrorCodeInvalidMessage},
+	{"answer without sdp", `{"version":1,"type":"answer","sdp":""}`, ErrorCodeInvalidMessage},
+	{"candidate missing", `{"version":1,"type":"ice-candidate"}`, ErrorCodeInvalidCandidate},
+	{"candidate without mid or index", `{"version":1,"type":"ice-candidate","candidate":{"candidate":"candidate:1 1 udp 1 10.0.0.1 5000 typ host"}}`, ErrorCodeInvalidCandidate},
+	{"error without error", `{"version":1,"type":"error"}`, ErrorCodeInvalidMessage},
+	{"timeshift without offset", `{"version":1,"type":"timeshift"}`, ErrorCodeInvalidMessage},
+	{"timeshift into the future", `{"version":1,"type":"timeshift","offset":-5}`, ErrorCodeInvalidMessage},
+	{"timeshift too fast", `{"version":1,"type":"timeshift","offset":30,"rate":10}`, ErrorCodeInvalidMessage},
+	{"timeshift slower than live", `{"version":1,"type":"timeshift","offset":30,"rate":0.5}`, ErrorCodeInvalidMessage},
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, test := range invalidMessages {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Decode([]byte(test.message))
+			var signalingErr *Error
+			if !errors.As(err, &signalingErr) {
+				t.Fatalf("got error %v, want an *Error", err)
+			}
+			if signalingErr.Code != test.code {
+				t.Errorf("got code %s, want %s", signalingErr.Code, test.code)
+			}
+		})
+	}
+}
+
+func TestDecodeValid(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    Message
+	}{
+		{
+			name:    "offer",
+			message: `{"version":1,"type":"offer","sdp":"v=0"}`,
+			want:    Message{Version: 1, Type: TypeOffer, SDP: "v=0"},
+		},
+		{
+			name:    "without version",
+			message: `{"type":"ping"}`,
+			want:    Message{Version: 1, Type: TypePing},
+		},
+		{
+			name:    "candidate with index 0 only",
+			message: `{"version":1,"type":"ice-candidate","candidate":{"candidate":"","sdpMLineIndex":0}}`,
+			want:    Message{Version: 1, Type: TypeICECandidate},
+		},
+		{
+			name:    "timeshift at live rate",
+			message: `{"version":1,"type":"timeshift","offset":30}`,
+			want:    Message{Version: 1, Type: TypeTimeshift, Offset: 30},
+		},
+		{
+			name:    "unknown fields",
+			message: `{"version":1,"type":"bye","reason":"closing"}`,
+			want:    Message{Version: 1, Type: TypeBye},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			message, err := Decode([]byte(test.message))
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if message.Version != test.want.Version || message.Type != test.want.Type || message.SDP != test.want.SDP || message.Offset != test.want.Offset {
+				t.Errorf("got %+v, want %+v", message, test.want)
+			}
+		})
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	_, err := Decode([]byte(`{"version":3,"type":"ping"}`))
+	message := ErrorMessage(ErrorCodeInvalidMessage, err)
+	if message.Type != TypeError || message.Version != ProtocolVersion || message.Error.Code != ErrorCodeUnsupportedVersion {
+		t.Errorf("got %+v %+v, want an unsupported_version error", message, message.Error)
+	}
+
+	message = ErrorMessage(ErrorCodeInternal, errors.New("camera unplugged"))
+	if message.Error.Code != ErrorCodeInternal || message.Error.Message != "camera unplugged" {
+		t.Errorf("got %+v, want the fallback code", message.Error)
+	}
+
+	// The reply must pass validation on the client as well
+	data, err := json.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decode(data); err != nil {
+		t.Errorf("error reply does not decode: %v", err)
+	}
+}
+
+// testPeer is a Peer without media, it handles no messages of its own.
+type testPeer struct {
+	pc *ext_webrtc.PeerConnection
+}
+
+func (p *testPeer) PeerConnection() *ext_webrtc.PeerConnection {
+	return p.pc
+}
+
+func (p *testPeer) Close() error {
+	return p.pc.Close()
+}
+
+// dialTestServer serves signaling for peers without media and connects a
+// client to it.
+func dialTestServer(t *testing.T) *websocket.Conn {
+	t.Helper()
+
+	server := NewServer("Test")
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.Serve(w, r, func(*http.Request) (Peer, error) {
+			pc, err := ext_webrtc.NewPeerConnection(ext_webrtc.Configuration{})
+			if err != nil {
+				return nil, err
+			}
+			return &testPeer{pc: pc}, nil
+		})
+	}))
+	t.Cleanup(httpServer.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(httpServer.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func exchange(t *testing.T, conn *websocket.Conn, request string) Message {
+	t.Helper()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(request)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var reply Message
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatalf("read reply to %s: %v", request, err)
+	}
+	return reply
+}
+
+func TestServerErrorReplies(t *testing.T) {
+	conn := dialTestServer(t)
+
+	tests := append(invalidMessages[:len(invalidMessages):len(invalidMessages)], []struct {
+		name    string
+		message string
+		code    string
+	}{
+		{"offer that is not SDP", `{"version":1,"type":"offer","sdp":"hello"}`, ErrorCodeNegotiationFailed},
+		{"candidate that is not one", `{"version":1,"type":"ice-candidate","candidate":{"candidate":"garbage","sdpMid":"0"}}`, ErrorCodeInvalidCandidate},
+		{"type the peer does not handle", `{"version":1,"type":"talk-start"}`, ErrorCodeUnknownType},
+	}...)
+
+	// Every message is answered on the same connection, errors never end the
+	// session
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reply := exchange(t, conn, test.message)
+			if reply.Type != TypeError || reply.Error == nil {
+				t.Fatalf("got %+v, want an error", reply)
+			}
+			if reply.Error.Code != test.code {
+				t.Errorf("got code %s (%s), want %s", reply.Error.Code, reply.Error.Message, test.code)
+			}
+			if reply.Version != ProtocolVersion {
+				t.Errorf("reply has version %d", reply.Version)
+			}
+		})
+	}
+
+	if reply := exchange(t, conn, `{"type":"ping"}`); reply.Type != TypePong {
+		t.Errorf("got %+v after the errors, want pong", reply)
+	}
+}
+
+func TestServerBye(t *testing.T) {
+	conn := dialTestServer(t)
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"version":1,"type":"bye"}`)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Error("session still open after bye")
+	}
+}
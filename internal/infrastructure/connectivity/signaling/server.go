@@ -0,0 +1,266 @@
+package signaling
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	ext_webrtc "github.com/pion/webrtc/v3"
+)
+
+const (
+	writeTimeout = 10 * time.Second
+	pongTimeout  = 60 * time.Second
+	pingInterval = pongTimeout * 9 / 10
+	maxMessage   = 64 * 1024
+)
+
+// Peer is the media side of a signaling session, created by the receiver or
+// the sender for every WebSocket connection and closed when it ends.
+type Peer interface {
+	PeerConnection() *ext_webrtc.PeerConnection
+	Close() error
+}
+
+// MessageHandler can be implemented by a Peer to handle message types beyond
+// the negotiation. A returned message is sent back to the client.
+type MessageHandler interface {
+	HandleSignalingMessage(message Message) (*Message, error)
+}
+
+// OfferHandler can be implemented by a Peer to prepare its PeerConnection for
+// a remote offer before it is answered, e.g. to pick the codecs of its tracks
+// from those the client supports.
+type OfferHandler interface {
+	HandleOffer(offerSDP string) error
+}
+
+// prepareOffer hands an offer to the peer, if it wants to see it.
+func prepareOffer(peer Peer, offerSDP string) error {
+	if handler, ok := peer.(OfferHandler); ok {
+		return handler.HandleOffer(offerSDP)
+	}
+	return nil
+}
+
+// PeerFactory creates the Peer of a new signaling session.
+type PeerFactory func(req *http.Request) (Peer, error)
+
+// Server owns the WebSocket side of signaling: upgrading connections, keeping
+// them alive, serialising writes and running the offer/answer/ICE exchange
+// against the PeerConnection handed over by a PeerFactory.
+type Server struct {
+	name     string
+	upgrader websocket.Upgrader
+}
+
+func NewServer(name string) *Server {
+	return &Server{
+		name: name,
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return true // Allow all origins for demo
+			},
+		},
+	}
+}
+
+// session is a single signaling WebSocket connection. Writes are serialised
+// as gorilla/websocket allows only one concurrent writer, while ICE candidates
+// are sent from pion's callback goroutines.
+type session struct {
+	conn       *websocket.Conn
+	writeMutex sync.Mutex
+	remoteAddr string
+}
+
+func (s *session) send(message Message) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return s.conn.WriteJSON(message)
+}
+
+func (s *session) sendError(code string, err error) {
+	if err := s.send(ErrorMessage(code, err)); err != nil {
+		fmt.Printf("Error sending signaling error: %v\n", err)
+	}
+}
+
+func (s *session) ping() error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	return s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+}
+
+// Serve upgrades the request and runs the signaling session until the client
+// leaves, says bye or stops answering pings.
+func (s *Server) Serve(w http.ResponseWriter, req *http.Request, newPeer PeerFactory) {
+	conn, err := s.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		fmt.Printf("WebSocket upgrade error: %v\n", err)
+		return
+	}
+	defer conn.Close()
+
+	fmt.Printf("%s WebSocket connection established from %s\n", s.name, req.RemoteAddr)
+	session := &session{conn: conn, remoteAddr: req.RemoteAddr}
+
+	peer, err := newPeer(req)
+	if err != nil {
+		fmt.Printf("Failed to initialize peer connection: %v\n", err)
+		session.sendError(ErrorCodeInternal, err)
+		return
+	}
+	defer peer.Close()
+
+	// Handle ICE candidates
+	peer.PeerConnection().OnICECandidate(func(candidate *ext_webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
+
+		if err := session.send(ICECandidateMessage(candidate.ToJSON())); err != nil {
+			fmt.Printf("Error sending ICE candidate: %v\n", err)
+		}
+	})
+
+	// Keep the connection alive, a client that stops answering pings is gone
+	conn.SetReadLimit(maxMessage)
+	conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.keepAlive(session, done)
+
+	// Listen for signaling messages
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			fmt.Printf("%s WebSocket read error: %v\n", s.name, err)
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(pongTimeout))
+
+		message, err := Decode(data)
+		if err != nil {
+			fmt.Printf("Invalid signaling message: %v\n", err)
+			session.sendError(ErrorCodeInvalidMessage, err)
+			continue
+		}
+
+		if !s.handleMessage(session, peer, message) {
+			return
+		}
+	}
+}
+
+func (s *Server) keepAlive(session *session, done chan struct{}) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := session.ping(); err != nil {
+				fmt.Printf("%s WebSocket ping error: %v\n", s.name, err)
+				return
+			}
+		}
+	}
+}
+
+// handleMessage processes a single message, it returns false once the
+// session should end.
+func (s *Server) handleMessage(session *session, peer Peer, message Message) bool {
+	pc := peer.PeerConnection()
+
+	switch message.Type {
+	case TypeOffer:
+		err := prepareOffer(peer, message.SDP)
+		answer := ""
+		if err == nil {
+			answer, err = Answer(pc, message.SDP)
+		}
+		if err != nil {
+			fmt.Printf("Error negotiating with %s: %v\n", session.remoteAddr, err)
+			session.sendError(ErrorCodeNegotiationFailed, err)
+			return true
+		}
+
+		fmt.Printf("Sending answer to %s (SDP length: %d)\n", session.remoteAddr, len(answer))
+		if err := session.send(AnswerMessage(answer)); err != nil {
+			fmt.Printf("Error sending answer: %v\n", err)
+		}
+
+	case TypeICECandidate:
+		if err := pc.AddICECandidate(*message.Candidate); err != nil {
+			fmt.Printf("Error adding ICE candidate: %v\n", err)
+			session.sendError(ErrorCodeInvalidCandidate, err)
+		}
+
+	case TypePing:
+		if err := session.send(PongMessage()); err != nil {
+			fmt.Printf("Error sending pong: %v\n", err)
+		}
+
+	case TypeBye:
+		fmt.Printf("%s client %s said bye\n", s.name, session.remoteAddr)
+		return false
+
+	case TypeError:
+		fmt.Printf("%s client %s reported error: %v\n", s.name, session.remoteAddr, message.Error)
+
+	default:
+		handler, ok := peer.(MessageHandler)
+		if !ok {
+			session.sendError(ErrorCodeUnknownType, NewError(ErrorCodeUnknownType, "unexpected message type %q", message.Type))
+			return true
+		}
+
+		reply, err := handler.HandleSignalingMessage(message)
+		if err != nil {
+			session.sendError(ErrorCodeInvalidMessage, err)
+			return true
+		}
+		if reply != nil {
+			if err := session.send(*reply); err != nil {
+				fmt.Printf("Error sending %s reply: %v\n", message.Type, err)
+			}
+		}
+	}
+
+	return true
+}
+
+// Answer applies a remote offer to pc and returns the local answer SDP.
+func Answer(pc *ext_webrtc.PeerConnection, offerSDP string) (string, error) {
+	offer := ext_webrtc.SessionDescription{
+		Type: ext_webrtc.SDPTypeOffer,
+		SDP:  offerSDP,
+	}
+
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		return "", fmt.Errorf("failed to set remote description: %v", err)
+	}
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create answer: %v", err)
+	}
+
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return "", fmt.Errorf("failed to set local description: %v", err)
+	}
+
+	return answer.SDP, nil
+}
@@ -0,0 +1,76 @@
+// Package signaling defines the JSON messages publishers and viewers exchange
+// with katkam over WebSocket to negotiate their PeerConnections.
+//
+// The client sends an offer, the server replies with an answer, and both sides
+// trickle ice-candidate messages afterwards. Failures are reported with an
+// error message instead of closing the socket, ping is answered with pong and
+// bye ends the session. Viewers can send timeshift to watch the stream with a
+// delay and live to return to the live stream, talk-start and talk-stop to
+// talk through the host's speaker. These are confirmed with a message of the
+// same type. Every message carries the protocol version, messages
+// without one are treated as version 1.
+package signaling
+
+import (
+	"fmt"
+
+	ext_webrtc "github.com/pion/webrtc/v3"
+)
+
+const ProtocolVersion = 1
+
+const (
+	TypeOffer        = "offer"
+	TypeAnswer       = "answer"
+	TypeICECandidate = "ice-candidate"
+	TypeError        = "error"
+	TypeBye          = "bye"
+	TypePing         = "ping"
+	TypePong         = "pong"
+	TypeTimeshift    = "timeshift"
+	TypeLive         = "live"
+	TypeTalkStart    = "talk-start"
+	TypeTalkStop     = "talk-stop"
+)
+
+// MaxTimeshiftRate is the fastest a timeshifted viewer can catch up with the
+// live stream
+const MaxTimeshiftRate = 4
+
+const (
+	ErrorCodeInvalidMessage     = "invalid_message"
+	ErrorCodeUnsupportedVersion = "unsupported_version"
+	ErrorCodeUnknownType        = "unknown_type"
+	ErrorCodeNegotiationFailed  = "negotiation_failed"
+	ErrorCodeInvalidCandidate   = "invalid_candidate"
+	ErrorCodeInternal           = "internal_error"
+	ErrorCodeTalkBusy           = "talk_busy"
+)
+
+type Message struct {
+	Version   int                          `json:"version"`
+	Type      string                       `json:"type"`
+	SDP       string                       `json:"sdp,omitempty"`
+	Candidate *ext_webrtc.ICECandidateInit `json:"candidate,omitempty"`
+	Error     *Error                       `json:"error,omitempty"`
+	// Offset is how many seconds a timeshifted viewer is behind live
+	Offset float64 `json:"offset,omitempty"`
+	// Rate is the playback speed of a timeshifted viewer, above 1 it catches
+	// up with the live stream
+	Rate float64 `json:"rate,omitempty"`
+}
+
+// Error is both the payload of error messages and the error returned when a
+// message cannot be handled.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func NewError(code string, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,432 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"katkam/internal/infrastructure/connectivity"
+	"katkam/internal/infrastructure/media/ivf"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+)
+
+const (
+	// sinkQueueSize holds a few seconds of frames while ffmpeg is busy
+	sinkQueueSize = 300
+
+	// Audio is only passed to ffmpeg when the source sent some recently,
+	// otherwise ffmpeg would wait for an audio stream that never comes
+	audioTimeout = 2 * time.Second
+)
+
+// ffmpegSink feeds the relayed stream into an ffmpeg process, video as IVF on
+// stdin and audio as Ogg/Opus on pipe:3. ffmpeg is started on a keyframe and
+// restarted on the next one whenever it exits, falls behind or the source
+// restarts its timeline or changes its video codec.
+type ffmpegSink struct {
+	name      string
+	args      func(codec string, withAudio bool) []string
+	withAudio bool
+	parse     func(ctx context.Context, reader io.Reader)
+
+	// active, when set, keeps ffmpeg from running while nobody needs it
+	active func() bool
+
+	keyframeProvider connectivity.KeyframeProvider
+
+	mutex     sync.Mutex
+	process   *sinkProcess
+	lastAudio time.Time
+	// keyframeRequested is set once the source was asked for the keyframe
+	// ffmpeg waits to start on
+	keyframeRequested bool
+}
+
+// newFFmpegSink creates a sink running ffmpeg with the arguments returned by
+// args for the video codec it starts on. ffmpeg's stdout is handed to parse,
+// or discarded when parse is nil.
+func newFFmpegSink(name string, withAudio bool, args func(codec string, withAudio bool) []string, parse func(ctx context.Context, reader io.Reader)) *ffmpegSink {
+	return &ffmpegSink{
+		name:      name,
+		args:      args,
+		withAudio: withAudio,
+		parse:     parse,
+	}
+}
+
+// AssignKeyframeProvider lets the sink ask the source for a keyframe instead
+// of waiting for the next one to start ffmpeg.
+func (s *ffmpegSink) AssignKeyframeProvider(provider connectivity.KeyframeProvider) {
+	s.keyframeProvider = provider
+}
+
+func (s *ffmpegSink) SendVideoFrame(frame connectivity.Frame) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.active != nil && !s.active() {
+		if s.process != nil {
+			fmt.Printf("%s is idle, stopping FFmpeg\n", s.name)
+			s.process.Stop()
+			s.process = nil
+		}
+		return
+	}
+
+	if s.process != nil && !s.process.accepts(frame) {
+		s.process.Stop()
+		s.process = nil
+	}
+
+	if s.process == nil {
+		if !frame.Keyframe {
+			s.requestKeyframe()
+			return
+		}
+
+		withAudio := s.withAudio && time.Since(s.lastAudio) < audioTimeout
+		process, err := startSinkProcess(s.name, s.args(frame.Codec, withAudio), frame.Codec, withAudio, s.parse)
+		if err != nil {
+			fmt.Printf("❌ Failed to start %s: %v\n", s.name, err)
+			return
+		}
+		s.process = process
+		s.keyframeRequested = false
+	}
+
+	if !s.process.writeVideo(frame) {
+		fmt.Printf("⚠️ %s falls behind, restarting on the next keyframe\n", s.name)
+		s.process.Stop()
+		s.process = nil
+	}
+}
+
+// requestKeyframe asks the source for a keyframe once per start of ffmpeg,
+// publishers may send them only every few seconds. Must be called with mutex
+// held.
+func (s *ffmpegSink) requestKeyframe() {
+	if s.keyframeProvider == nil || s.keyframeRequested {
+		return
+	}
+	s.keyframeRequested = true
+	// The request goes out on the network, the relay's goroutine must not wait
+	go s.keyframeProvider.RequestKeyframe()
+}
+
+func (s *ffmpegSink) SendAudioFrame(frame connectivity.Frame) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastAudio = time.Now()
+	if s.process != nil {
+		s.process.writeAudio(frame)
+	}
+}
+
+// Running reports whether ffmpeg is currently processing the stream.
+func (s *ffmpegSink) Running() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.process != nil && !s.process.exited()
+}
+
+func (s *ffmpegSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.process != nil {
+		s.process.Stop()
+		s.process = nil
+	}
+	return nil
+}
+
+// sinkProcess is a single ffmpeg run. Video and audio are written by their
+// own goroutines, so ffmpeg waiting on one input never blocks the other.
+type sinkProcess struct {
+	name   string
+	codec  string
+	cmd    *exec.Cmd
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	videoChannel    chan connectivity.Frame
+	audioChannel    chan connectivity.Frame
+	stoppingChannel chan struct{}
+	stopOnce        sync.Once
+
+	started   bool
+	videoBase time.Duration
+	lastPTS   time.Duration
+	audioBase time.Duration
+	audioSeen bool
+}
+
+func startSinkProcess(name string, args []string, codec string, withAudio bool, parse func(ctx context.Context, reader io.Reader)) (*sinkProcess, error) {
+	if ivf.FourCC(codec) == "" {
+		return nil, fmt.Errorf("unsupported video codec %s", codec)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create stdin pipe: %v", err)
+	}
+
+	var audioReader, audioWriter *os.File
+	if withAudio {
+		audioReader, audioWriter, err = os.Pipe()
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to create audio pipe: %v", err)
+		}
+		// Becomes pipe:3 in ffmpeg
+		cmd.ExtraFiles = []*os.File{audioReader}
+	}
+
+	var stdout io.ReadCloser
+	if parse != nil {
+		if stdout, err = cmd.StdoutPipe(); err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to create stdout pipe: %v", err)
+		}
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create stderr pipe: %v", err)
+	}
+
+	fmt.Printf("📹 Starting %s FFmpeg command: %s\n", name, cmd.String())
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to start ffmpeg: %v", err)
+	}
+	if audioReader != nil {
+		audioReader.Close()
+	}
+
+	p := &sinkProcess{
+		name:            name,
+		codec:           codec,
+		cmd:             cmd,
+		cancel:          cancel,
+		done:            make(chan struct{}),
+		videoChannel:    make(chan connectivity.Frame, sinkQueueSize),
+		stoppingChannel: make(chan struct{}),
+	}
+
+	// Log stderr in background
+	logged := make(chan struct{})
+	go func() {
+		defer close(logged)
+		buffer := make([]byte, 1024)
+		for {
+			n, err := stderr.Read(buffer)
+			if err != nil {
+				break
+			}
+			if n > 0 {
+				fmt.Printf("FFmpeg %s stderr: %s", name, string(buffer[:n]))
+			}
+		}
+	}()
+
+	// cmd.Wait closes stdout and stderr, so it must only run once both
+	// were read to the end
+	parsed := make(chan struct{})
+	go func() {
+		defer close(parsed)
+		if stdout == nil {
+			return
+		}
+		parse(ctx, stdout)
+		// Keep ffmpeg from blocking on a parser that gave up early
+		io.Copy(io.Discard, stdout)
+	}()
+
+	go p.writeVideoStream(stdin)
+	if audioWriter != nil {
+		p.audioChannel = make(chan connectivity.Frame, sinkQueueSize)
+		go p.writeAudioStream(audioWriter)
+	}
+
+	go func() {
+		<-parsed
+		<-logged
+		if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+			fmt.Printf("❌ %s FFmpeg exited: %v\n", name, err)
+		}
+		close(p.done)
+		p.Stop()
+	}()
+
+	return p, nil
+}
+
+// accepts reports whether frame continues the stream ffmpeg was started on,
+// a source restarting its timestamps or switching codecs needs a new process.
+func (p *sinkProcess) accepts(frame connectivity.Frame) bool {
+	return !p.exited() && frame.Codec == p.codec && (!p.started || frame.PTS >= p.lastPTS)
+}
+
+func (p *sinkProcess) exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// writeVideo queues a video frame, it returns false when ffmpeg cannot keep up.
+func (p *sinkProcess) writeVideo(frame connectivity.Frame) bool {
+	if !p.started {
+		p.started = true
+		p.videoBase = frame.PTS
+	}
+	p.lastPTS = frame.PTS
+	frame.PTS -= p.videoBase
+
+	select {
+	case p.videoChannel <- frame:
+		return true
+	default:
+		return false
+	}
+}
+
+// writeAudio queues an audio frame once video started, dropping it when
+// ffmpeg cannot keep up.
+func (p *sinkProcess) writeAudio(frame connectivity.Frame) {
+	if p.audioChannel == nil || !p.started {
+		return
+	}
+	if !p.audioSeen {
+		p.audioSeen = true
+		p.audioBase = frame.PTS
+	}
+	if frame.PTS < p.audioBase {
+		return
+	}
+	frame.PTS -= p.audioBase
+
+	select {
+	case p.audioChannel <- frame:
+	default:
+	}
+}
+
+func (p *sinkProcess) writeVideoStream(stdin io.WriteCloser) {
+	defer stdin.Close()
+
+	writer, err := ivf.NewWriter(stdin, ivf.FileHeader{
+		FourCC:              ivf.FourCC(p.codec),
+		TimebaseNumerator:   1,
+		TimebaseDenominator: 1000,
+	})
+	if err != nil {
+		fmt.Printf("Error writing IVF header to %s: %v\n", p.name, err)
+		p.Stop()
+		return
+	}
+
+	write := func(frame connectivity.Frame) bool {
+		if err := writer.WriteFrame(ivf.Frame{PTS: frame.PTS, Payload: frame.Data}); err != nil {
+			fmt.Printf("Error writing video to %s: %v\n", p.name, err)
+			p.Stop()
+			return false
+		}
+		return true
+	}
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-p.stoppingChannel:
+			p.drain(p.videoChannel, write)
+			return
+		case frame := <-p.videoChannel:
+			if !write(frame) {
+				return
+			}
+		}
+	}
+}
+
+func (p *sinkProcess) writeAudioStream(pipe *os.File) {
+	defer pipe.Close()
+
+	writer, err := oggwriter.NewWith(pipe, 48000, 2)
+	if err != nil {
+		fmt.Printf("Error writing Ogg header to %s: %v\n", p.name, err)
+		return
+	}
+
+	write := func(frame connectivity.Frame) bool {
+		packet := &rtp.Packet{
+			Header:  rtp.Header{Timestamp: connectivity.OggTimestamp(frame.PTS)},
+			Payload: frame.Data,
+		}
+		if err := writer.WriteRTP(packet); err != nil {
+			fmt.Printf("Error writing audio to %s: %v\n", p.name, err)
+			return false
+		}
+		return true
+	}
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-p.stoppingChannel:
+			p.drain(p.audioChannel, write)
+			return
+		case frame := <-p.audioChannel:
+			if !write(frame) {
+				return
+			}
+		}
+	}
+}
+
+// drain writes the frames still queued when the process is stopped, so ffmpeg
+// can finish its output with them.
+func (p *sinkProcess) drain(frames chan connectivity.Frame, write func(connectivity.Frame) bool) {
+	for {
+		select {
+		case frame := <-frames:
+			if !write(frame) {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Stop closes the inputs so ffmpeg can finish its output, and kills it if it
+// does not exit shortly after.
+func (p *sinkProcess) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stoppingChannel)
+
+		go func() {
+			select {
+			case <-p.done:
+			case <-time.After(5 * time.Second):
+			}
+			p.cancel()
+		}()
+	})
+}
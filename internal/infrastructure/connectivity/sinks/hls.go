@@ -0,0 +1,204 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+	"katkam/internal/config"
+	"katkam/internal/infrastructure/connectivity"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// PlaylistName is the playlist players open, in both HLS and low latency mode.
+const PlaylistName = "index.m3u8"
+
+// Segmenting keeps running this long after the last request, players reload
+// the playlist about once per segment
+const hlsKeepAlive = 30 * time.Second
+
+// HLS segments the relayed stream into a rolling HLS playlist for viewers
+// that cannot establish a WebRTC session. VP8 video is encoded to H.264 with
+// keyframes at the segment boundaries, H.264 video is copied and cut at the
+// source's keyframes. Opus is encoded to AAC, in fMP4 segments. ffmpeg only
+// runs while players requested the playlist or segments recently.
+//
+// The low latency mode uses ffmpeg's DASH muxer in LHLS mode, which writes
+// chunked CMAF segments and announces the upcoming one with a prefetch hint
+// so players can fetch it while it is still being written.
+type HLS struct {
+	*ffmpegSink
+
+	Config    config.HLS
+	directory string
+
+	mutex       sync.Mutex
+	lastRequest time.Time
+}
+
+func NewHLS(cfg config.HLS) (*HLS, error) {
+	directory := cfg.Directory
+	if directory == "" {
+		var err error
+		if directory, err = os.MkdirTemp("", "katkam-hls-"); err != nil {
+			return nil, fmt.Errorf("failed to create HLS directory: %v", err)
+		}
+	} else if err := os.MkdirAll(directory, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create HLS directory: %v", err)
+	}
+
+	h := &HLS{
+		Config:    cfg,
+		directory: directory,
+	}
+	h.ffmpegSink = newFFmpegSink("HLS", cfg.Audio, h.args, nil)
+	h.ffmpegSink.active = h.watched
+
+	fmt.Printf("📺 HLS segments are written to %s\n", directory)
+	return h, nil
+}
+
+// Directory holds the playlists and segments to be served.
+func (h *HLS) Directory() string {
+	return h.directory
+}
+
+// Ready reports whether a playlist is being written.
+func (h *HLS) Ready() bool {
+	if !h.Running() {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(h.directory, PlaylistName))
+	return err == nil
+}
+
+// Request records that a player fetched a playlist or segment, which keeps
+// segmenting running or starts it on the next keyframe.
+func (h *HLS) Request() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.lastRequest = time.Now()
+}
+
+// WaitReady waits until a playlist is being written, after segmenting was
+// idle the first one takes a segment duration to appear.
+func (h *HLS) WaitReady(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for !h.Ready() {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("no playlist written in time: %v", ctx.Err())
+		}
+	}
+	return nil
+}
+
+func (h *HLS) watched() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return time.Since(h.lastRequest) < hlsKeepAlive
+}
+
+// args is called on every (re)start of ffmpeg. Segments of a previous run
+// belong to another timeline, so they are removed first and the new run is
+// numbered from the current time to keep media sequence numbers increasing.
+func (h *HLS) args(codec string, withAudio bool) []string {
+	h.clearDirectory()
+	run := time.Now().Unix()
+
+	args := []string{
+		"-f", "ivf",
+		"-i", "pipe:0",
+	}
+	if withAudio {
+		args = append(args, "-f", "ogg", "-i", "pipe:3")
+	}
+
+	args = append(args, "-map", "0:v:0")
+	if withAudio {
+		args = append(args, "-map", "1:a:0")
+	}
+
+	// Keep the source timestamps, IVF claims a 1000fps rate
+	args = append(args, "-vsync", "passthrough")
+	if codec == connectivity.CodecH264 {
+		// Players decode H.264 as it is, segments are cut at the source's
+		// keyframes
+		args = append(args, "-c:v", "copy")
+	} else {
+		args = append(args,
+			"-c:v", "libx264",
+			"-preset", "veryfast",
+			"-tune", "zerolatency",
+			"-pix_fmt", "yuv420p",
+			"-b:v", h.Config.Bitrate,
+			// Segments can only be cut on keyframes
+			"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", h.Config.SegmentDuration),
+		)
+	}
+	if withAudio {
+		args = append(args, "-c:a", "aac", "-b:a", "64k")
+	}
+
+	if h.Config.LowLatency {
+		return append(args,
+			"-f", "dash",
+			"-ldash", "1",
+			"-lhls", "1",
+			"-streaming", "1",
+			"-hls_playlist", "1",
+			"-hls_master_name", PlaylistName,
+			"-seg_duration", fmt.Sprintf("%d", h.Config.SegmentDuration),
+			"-frag_type", "duration",
+			"-frag_duration", "0.5",
+			"-window_size", fmt.Sprintf("%d", h.Config.PlaylistSize),
+			"-extra_window_size", "2",
+			"-use_template", "1",
+			"-use_timeline", "0",
+			"-format_options", "movflags=cmaf",
+			"-init_seg_name", fmt.Sprintf("init-%d-$RepresentationID$.$ext$", run),
+			"-media_seg_name", fmt.Sprintf("chunk-%d-$RepresentationID$-$Number%%05d$.$ext$", run),
+			filepath.Join(h.directory, "manifest.mpd"),
+		)
+	}
+
+	return append(args,
+		"-f", "hls",
+		"-hls_time", fmt.Sprintf("%d", h.Config.SegmentDuration),
+		"-hls_list_size", fmt.Sprintf("%d", h.Config.PlaylistSize),
+		"-hls_flags", "delete_segments+independent_segments",
+		"-hls_segment_type", "fmp4",
+		"-hls_fmp4_init_filename", fmt.Sprintf("init-%d.mp4", run),
+		"-start_number", fmt.Sprintf("%d", run),
+		"-hls_segment_filename", filepath.Join(h.directory, fmt.Sprintf("segment-%d-%%d.m4s", run)),
+		filepath.Join(h.directory, PlaylistName),
+	)
+}
+
+// clearDirectory removes playlists and segments, leaving anything else in a
+// configured directory alone.
+func (h *HLS) clearDirectory() {
+	entries, err := os.ReadDir(h.directory)
+	if err != nil {
+		fmt.Printf("Error reading HLS directory: %v\n", err)
+		return
+	}
+
+	for _, entry := range entries {
+		switch filepath.Ext(entry.Name()) {
+		case ".m3u8", ".mpd", ".m4s", ".mp4", ".tmp":
+		default:
+			continue
+		}
+		if entry.IsDir() {
+			continue
+		}
+		if err := os.Remove(filepath.Join(h.directory, entry.Name())); err != nil {
+			fmt.Printf("Error removing old HLS file: %v\n", err)
+		}
+	}
+}
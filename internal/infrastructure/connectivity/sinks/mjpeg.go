@@ -0,0 +1,171 @@
+package sinks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"katkam/internal/config"
+	"mime/multipart"
+	"sync"
+	"time"
+)
+
+const (
+	// ffmpeg's mpjpeg muxer separates the images with this boundary
+	mpjpegBoundary = "ffmpeg"
+	maxJPEGSize    = 8 * 1024 * 1024
+
+	// Decoding keeps running this long after the last snapshot request, so
+	// dashboards polling for snapshots get them right away
+	snapshotKeepAlive = 30 * time.Second
+)
+
+var ErrTooManyClients = errors.New("too many MJPEG clients")
+
+// MJPEG decodes the relayed video to JPEG images, for snapshots and
+// multipart MJPEG streams. ffmpeg only runs while there are MJPEG clients or
+// snapshots were requested recently.
+type MJPEG struct {
+	*ffmpegSink
+
+	Config config.MJPEG
+
+	mutex        sync.Mutex
+	latest       []byte
+	latestAt     time.Time
+	updated      chan struct{}
+	clients      map[chan []byte]struct{}
+	lastSnapshot time.Time
+}
+
+func NewMJPEG(cfg config.MJPEG) *MJPEG {
+	m := &MJPEG{
+		Config:  cfg,
+		updated: make(chan struct{}),
+		clients: make(map[chan []byte]struct{}),
+	}
+	m.ffmpegSink = newFFmpegSink("MJPEG", false, m.args, m.readImages)
+	m.ffmpegSink.active = m.wanted
+
+	return m
+}
+
+func (m *MJPEG) args(string, bool) []string {
+	filter := fmt.Sprintf("fps=%d", m.Config.Framerate)
+	if m.Config.Width > 0 {
+		filter += fmt.Sprintf(",scale=%d:-2", m.Config.Width)
+	}
+
+	return []string{
+		"-f", "ivf",
+		"-i", "pipe:0",
+		"-an",
+		"-vf", filter,
+		"-c:v", "mjpeg",
+		"-pix_fmt", "yuvj420p",
+		"-q:v", fmt.Sprintf("%d", m.Config.Quality),
+		"-f", "mpjpeg",
+		"-",
+	}
+}
+
+func (m *MJPEG) wanted() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.clients) > 0 || time.Since(m.lastSnapshot) < snapshotKeepAlive
+}
+
+// readImages splits ffmpeg's multipart output into JPEG images.
+func (m *MJPEG) readImages(ctx context.Context, reader io.Reader) {
+	parts := multipart.NewReader(reader, mpjpegBoundary)
+	for {
+		part, err := parts.NextPart()
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				fmt.Printf("MJPEG stream ended: %v\n", err)
+			}
+			return
+		}
+
+		image, err := io.ReadAll(io.LimitReader(part, maxJPEGSize))
+		if err != nil {
+			fmt.Printf("Error reading JPEG image: %v\n", err)
+			return
+		}
+		m.publish(image)
+	}
+}
+
+func (m *MJPEG) publish(image []byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.latest = image
+	m.latestAt = time.Now()
+	close(m.updated)
+	m.updated = make(chan struct{})
+
+	for client := range m.clients {
+		select {
+		case client <- image:
+		default:
+			// Slow clients skip images
+		}
+	}
+}
+
+// Snapshot returns the latest image, waiting for a new one when decoding was
+// idle or the latest image is stale.
+func (m *MJPEG) Snapshot(ctx context.Context) ([]byte, error) {
+	m.mutex.Lock()
+	m.lastSnapshot = time.Now()
+	if m.fresh() {
+		image := m.latest
+		m.mutex.Unlock()
+		return image, nil
+	}
+	updated := m.updated
+	m.mutex.Unlock()
+
+	select {
+	case <-updated:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no image decoded in time: %v", ctx.Err())
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.latest, nil
+}
+
+// fresh reports whether the latest image is recent enough to be served, it
+// is stale once a couple of images were missed. Must be called with mutex held.
+func (m *MJPEG) fresh() bool {
+	maxAge := 2 * time.Second / time.Duration(m.Config.Framerate)
+	return m.latest != nil && time.Since(m.latestAt) < maxAge
+}
+
+// Subscribe registers an MJPEG client, which receives every new image until
+// it calls the returned function.
+func (m *MJPEG) Subscribe() (<-chan []byte, func(), error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if len(m.clients) >= m.Config.MaxClients {
+		return nil, nil, ErrTooManyClients
+	}
+
+	client := make(chan []byte, 1)
+	m.clients[client] = struct{}{}
+	if m.fresh() {
+		client <- m.latest
+	}
+
+	unsubscribe := func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		delete(m.clients, client)
+	}
+	return client, unsubscribe, nil
+}
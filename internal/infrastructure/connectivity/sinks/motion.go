@@ -0,0 +1,69 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"katkam/internal/infrastructure/motion"
+	"time"
+)
+
+// Motion decodes the relayed video to small grayscale frames and hands them
+// to a motion detector. Any source works, the camera included, as its
+// encoded stream passes the relay as well.
+type Motion struct {
+	*ffmpegSink
+
+	Detector *motion.Detector
+}
+
+func NewMotion(detector *motion.Detector) *Motion {
+	m := &Motion{
+		Detector: detector,
+	}
+	m.ffmpegSink = newFFmpegSink("Motion detection", false, m.args, m.readFrames)
+
+	return m
+}
+
+func (m *Motion) args(string, bool) []string {
+	cfg := m.Detector.Config
+	return []string{
+		"-f", "ivf",
+		"-i", "pipe:0",
+		"-an",
+		"-vf", fmt.Sprintf("fps=%d,scale=%d:%d,format=gray", cfg.Framerate, cfg.Width, cfg.Height),
+		"-f", "rawvideo",
+		"-pix_fmt", "gray",
+		"-",
+	}
+}
+
+// readFrames cuts ffmpeg's raw output into frames.
+func (m *Motion) readFrames(ctx context.Context, reader io.Reader) {
+	// Motion cannot be followed once ffmpeg exits, the next process starts
+	// from scratch
+	defer m.Detector.Reset()
+
+	frame := make([]byte, m.Detector.FrameSize())
+	for {
+		if _, err := io.ReadFull(reader, frame); err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				fmt.Printf("Motion detection stream ended: %v\n", err)
+			}
+			return
+		}
+		m.Detector.Analyze(frame, time.Now())
+	}
+}
+
+func (m *Motion) SourceConnected() {}
+
+// SourceDisconnected ends ongoing motion, as no frames will tell.
+func (m *Motion) SourceDisconnected() {
+	m.Detector.Reset()
+}
+
+func (m *Motion) Status() map[string]interface{} {
+	return m.Detector.Status()
+}
@@ -0,0 +1,97 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"katkam/internal/infrastructure/connectivity"
+	"katkam/internal/infrastructure/media/ivf"
+	"time"
+)
+
+const (
+	transcodeBitrate = "1M"
+	// Viewers of the transcoded video cannot be primed from the source's
+	// group of pictures, they wait at most this long for a keyframe
+	transcodeKeyframeInterval = 2
+)
+
+// Transcoder re-encodes the relayed video to VP8 for viewers that cannot
+// decode the source's codec, e.g. browsers without H.264 watching an H.264
+// camera. ffmpeg only runs while such viewers watch.
+type Transcoder struct {
+	*ffmpegSink
+
+	onFrame func(connectivity.Frame)
+}
+
+// NewTranscoder creates the sink, needed reports whether viewers wait for
+// video in a codec and onFrame receives the transcoded frames.
+func NewTranscoder(needed func(codec string) bool, onFrame func(connectivity.Frame)) *Transcoder {
+	t := &Transcoder{
+		onFrame: onFrame,
+	}
+	t.ffmpegSink = newFFmpegSink("Transcoder", false, t.args, t.readFrames)
+	t.active = func() bool {
+		return needed(connectivity.CodecVP8)
+	}
+
+	return t
+}
+
+func (t *Transcoder) args(string, bool) []string {
+	return []string{
+		"-f", "ivf",
+		"-i", "pipe:0",
+		"-an",
+		"-vsync", "passthrough", // Keep the source timestamps, IVF claims a 1000fps rate
+		"-c:v", "libvpx",
+		"-deadline", "realtime",
+		"-cpu-used", "8",
+		"-lag-in-frames", "0",
+		"-error-resilient", "1",
+		"-b:v", transcodeBitrate,
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", transcodeKeyframeInterval),
+		"-f", "ivf",
+		"-",
+	}
+}
+
+// readFrames hands the VP8 frames ffmpeg writes to onFrame.
+func (t *Transcoder) readFrames(ctx context.Context, reader io.Reader) {
+	ivfReader, err := ivf.NewReader(reader)
+	if err != nil {
+		fmt.Printf("Failed to read transcoded IVF header: %v\n", err)
+		return
+	}
+
+	var previous time.Duration
+	for ctx.Err() == nil {
+		frame, err := ivfReader.ReadFrame()
+		if err != nil {
+			if err != io.EOF {
+				fmt.Printf("Transcoded stream ended: %v\n", err)
+			}
+			return
+		}
+
+		t.onFrame(connectivity.Frame{
+			Data:     frame.Payload,
+			PTS:      frame.PTS,
+			Duration: frame.PTS - previous,
+			Keyframe: connectivity.IsVP8Keyframe(frame.Payload),
+			Codec:    connectivity.CodecVP8,
+		})
+		previous = frame.PTS
+	}
+}
+
+// Status reports whether video is being transcoded in the status API.
+func (t *Transcoder) Status() map[string]interface{} {
+	return map[string]interface{}{
+		"transcoder": map[string]interface{}{
+			"running": t.Running(),
+			"codec":   connectivity.CodecVP8,
+		},
+	}
+}
@@ -1,10 +1,51 @@
 package connectivity
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	CodecVP8  = "video/VP8"
+	CodecH264 = "video/H264"
+	CodecOpus = "audio/opus"
+)
+
+// Frame is an encoded media frame travelling from a receiver to the senders.
+type Frame struct {
+	Data     []byte
+	PTS      time.Duration // Presentation timestamp, relative to the start of the stream
+	Duration time.Duration
+	Keyframe bool
+	Codec    string // Mime type of the payload, e.g. video/VP8
+}
+
+// TimedFrame is a frame kept in the relay's DVR buffer.
+type TimedFrame struct {
+	Frame
+	Video      bool
+	ReceivedAt time.Time
+	Sequence   uint64 // Position in the buffer, increasing by one per frame
+}
+
+// ViewerStats describes a single viewer session of a Sender.
+type ViewerStats struct {
+	ID                 string    `json:"id"`
+	RemoteAddr         string    `json:"remote_addr"`
+	State              string    `json:"state"`
+	CreatedAt          time.Time `json:"created_at"`
+	ConnectedAt        time.Time `json:"connected_at"`
+	VideoFramesSent    uint64    `json:"video_frames_sent"`
+	VideoFramesDropped uint64    `json:"video_frames_dropped"`
+	AudioFramesSent    uint64    `json:"audio_frames_sent"`
+	AudioFramesDropped uint64    `json:"audio_frames_dropped"`
+	Timeshifted        bool      `json:"timeshifted"`
+	VideoCodec         string    `json:"video_codec"`
+}
 
 type VideoStreamer struct {
-	OnVideoFrame   func([]byte)
-	OnAudioFrame   func([]byte)
+	OnVideoFrame   func(Frame)
+	OnAudioFrame   func(Frame)
 	OnConnected    func()
 	OnDisconnected func()
 }
@@ -16,16 +57,74 @@ type Socket interface {
 	HandleWebSocketConnection(w http.ResponseWriter, req *http.Request)
 }
 
+// HTTPSignaler is implemented by sockets that also negotiate over plain HTTP
+// (WHIP for receivers, WHEP for senders).
+type HTTPSignaler interface {
+	HandleHTTPSignaling(w http.ResponseWriter, req *http.Request)
+}
+
+// KeyframeProvider lets senders start new viewers on a keyframe and ask the
+// source for a fresh one.
+type KeyframeProvider interface {
+	// WithGroupOfPictures calls fn with the video frames since the last
+	// keyframe, starting with it. No frames are relayed while fn runs.
+	WithGroupOfPictures(fn func(frames []Frame))
+	RequestKeyframe()
+}
+
+// Timeshifter gives access to the last minutes of the relayed stream, so
+// viewers can watch with a delay.
+type Timeshifter interface {
+	// Seek returns the sequence number and receive time of the buffered
+	// keyframe nearest to at, ok is false when nothing is buffered.
+	Seek(at time.Time) (sequence uint64, receivedAt time.Time, ok bool)
+	// ReadFrames returns up to max frames starting at sequence, ok is false
+	// when the frame at sequence was already evicted.
+	ReadFrames(sequence uint64, max int) (frames []TimedFrame, ok bool)
+}
+
+// Sink consumes the relayed stream besides the sender, e.g. to transcode or
+// record it. Frames are handed over from the relay's goroutine, so sinks must
+// not block.
+type Sink interface {
+	SendVideoFrame(frame Frame)
+	SendAudioFrame(frame Frame)
+	Close() error
+}
+
+// SourceObserver is implemented by sinks that need to know when the source
+// of the relayed stream comes and goes.
+type SourceObserver interface {
+	SourceConnected()
+	SourceDisconnected()
+}
+
+// KeyframeConsumer is implemented by sinks that ask the source for a
+// keyframe when they (re)start.
+type KeyframeConsumer interface {
+	AssignKeyframeProvider(provider KeyframeProvider)
+}
+
+// StatusReporter is implemented by sinks (and senders) adding their state to
+// the status API, the returned keys are merged into the relay's status.
+type StatusReporter interface {
+	Status() map[string]interface{}
+}
+
 type Receiver interface {
 	Socket
 	AssignDisconnectedCallback(func())
 	AssignConnectedCallback(func())
-	AssignAudioFrameCallback(func(d []byte))
-	AssignVideoFrameCallback(func(data []byte))
+	AssignAudioFrameCallback(func(frame Frame))
+	AssignVideoFrameCallback(func(frame Frame))
+	RequestKeyframe()
 }
 
 type Sender interface {
 	Socket
-	SendVideoFrame(data []byte)
-	SendAudioFrame(data []byte)
+	AssignKeyframeProvider(provider KeyframeProvider)
+	AssignTimeshifter(timeshifter Timeshifter)
+	SendVideoFrame(frame Frame)
+	SendAudioFrame(frame Frame)
+	Viewers() []ViewerStats
 }
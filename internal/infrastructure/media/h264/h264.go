@@ -0,0 +1,99 @@
+// Package h264 reads H.264 in Annex-B, the byte stream format ffmpeg writes
+// with -f h264 where NAL units are separated by start codes. Access units
+// (the NAL units of one picture) are kept in Annex-B, which is also what the
+// RTP packetizer expects.
+package h264
+
+import "bytes"
+
+// NAL unit types, see table 7-1 of ITU-T H.264
+const (
+	NALUTypeSlice = 1
+	NALUTypeIDR   = 5
+	NALUTypeSEI   = 6
+	NALUTypeSPS   = 7
+	NALUTypePPS   = 8
+	NALUTypeAUD   = 9
+)
+
+var startCode = []byte{0, 0, 1}
+
+// NALUType returns the type of a NAL unit without its start code.
+func NALUType(nalu []byte) byte {
+	if len(nalu) == 0 {
+		return 0
+	}
+	return nalu[0] & 0x1f
+}
+
+// NALUnits splits an Annex-B access unit into its NAL units, without start
+// codes.
+func NALUnits(data []byte) [][]byte {
+	var units [][]byte
+	for len(data) > 0 {
+		start := bytes.Index(data, startCode)
+		if start < 0 {
+			// A unit not preceded by a start code, e.g. a bare NAL unit
+			units = append(units, data)
+			break
+		}
+		if start > 0 {
+			if unit := trimTrailingZeros(data[:start]); len(unit) > 0 {
+				units = append(units, unit)
+			}
+		}
+
+		data = data[start+len(startCode):]
+		end := bytes.Index(data, startCode)
+		if end < 0 {
+			if unit := trimTrailingZeros(data); len(unit) > 0 {
+				units = append(units, unit)
+			}
+			break
+		}
+		if unit := trimTrailingZeros(data[:end]); len(unit) > 0 {
+			units = append(units, unit)
+		}
+		data = data[end:]
+	}
+	return units
+}
+
+// IsKeyframe reports whether an access unit holds an IDR picture, which
+// decoders can start from.
+func IsKeyframe(data []byte) bool {
+	for _, unit := range NALUnits(data) {
+		if NALUType(unit) == NALUTypeIDR {
+			return true
+		}
+	}
+	return false
+}
+
+// Dimensions reads the picture size from the SPS of an access unit, encoders
+// repeat it in front of every IDR picture.
+func Dimensions(data []byte) (width, height uint16, ok bool) {
+	for _, unit := range NALUnits(data) {
+		if NALUType(unit) == NALUTypeSPS {
+			return spsDimensions(unit)
+		}
+	}
+	return 0, 0, false
+}
+
+// ProfileLevel reads profile_idc, the constraint flags and level_idc from the
+// SPS of an access unit, the three bytes of an SDP profile-level-id.
+func ProfileLevel(data []byte) (profile, constraints, level byte, ok bool) {
+	for _, unit := range NALUnits(data) {
+		if NALUType(unit) == NALUTypeSPS && len(unit) >= 4 {
+			return unit[1], unit[2], unit[3], true
+		}
+	}
+	return 0, 0, 0, false
+}
+
+// trimTrailingZeros drops the zero bytes between a NAL unit and the next
+// start code, the leading byte of 4 byte start codes and trailing_zero_8bits.
+func trimTrailingZeros(unit []byte) []byte {
+	return bytes.TrimRight(unit, "\x00")
+}
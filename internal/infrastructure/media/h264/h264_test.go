@@ -0,0 +1,156 @@
+package h264
+
+import (
+	"bytes"
+	"testing"
+)
+
+var (
+	testPPS   = []byte{0x68, 0xee, 0x3c, 0x80}
+	testIDR   = []byte{0x65, 0x88, 0x84, 0x00, 0x33}
+	testSlice = []byte{0x41, 0x9a, 0x02, 0x04}
+	testAUD   = []byte{0x09, 0xf0}
+)
+
+// annexB joins NAL units with 4 byte start codes, as the Reader returns them.
+func annexB(units ...[]byte) []byte {
+	var data []byte
+	for _, unit := range units {
+		data = append(data, 0, 0, 0, 1)
+		data = append(data, unit...)
+	}
+	return data
+}
+
+func TestNALUnits(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want [][]byte
+	}{
+		{
+			name: "4 byte start codes",
+			data: annexB(spsHigh1080, testPPS, testIDR),
+			want: [][]byte{spsHigh1080, testPPS, testIDR},
+		},
+		{
+			name: "3 byte start codes",
+			data: bytes.Join([][]byte{nil, testAUD, testSlice}, []byte{0, 0, 1}),
+			want: [][]byte{testAUD, testSlice},
+		},
+		{
+			name: "trailing zeros",
+			data: append(annexB(testAUD, testSlice), 0, 0),
+			want: [][]byte{testAUD, testSlice},
+		},
+		{
+			name: "data before the first start code",
+			data: append([]byte{0x06, 0x05}, annexB(testIDR)...),
+			want: [][]byte{{0x06, 0x05}, testIDR},
+		},
+		{
+			name: "bare NAL unit",
+			data: testIDR,
+			want: [][]byte{testIDR},
+		},
+		{
+			name: "empty units",
+			data: []byte{0, 0, 1, 0, 0, 1, 0x09, 0xf0, 0, 0, 1},
+			want: [][]byte{testAUD},
+		},
+		{name: "empty"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			units := NALUnits(test.data)
+			if len(units) != len(test.want) {
+				t.Fatalf("got %d units %x, want %d", len(units), units, len(test.want))
+			}
+			for i := range units {
+				if !bytes.Equal(units[i], test.want[i]) {
+					t.Errorf("unit %d is %x, want %x", i, units[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestIsKeyframe(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"IDR with parameter sets", annexB(testAUD, spsBaseline480, testPPS, testIDR), true},
+		{"bare IDR", annexB(testIDR), true},
+		{"non-IDR slice", annexB(testAUD, testSlice), false},
+		{"parameter sets only", annexB(spsBaseline480, testPPS), false},
+		{"empty", nil, false},
+	}
+	for _, test := range tests {
+		if got := IsKeyframe(test.data); got != test.want {
+			t.Errorf("%s: IsKeyframe = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDimensions(t *testing.T) {
+	width, height, ok := Dimensions(annexB(testAUD, spsMain720, testPPS, testIDR))
+	if width != 1280 || height != 720 || !ok {
+		t.Errorf("got %dx%d %v, want 1280x720", width, height, ok)
+	}
+	if _, _, ok := Dimensions(annexB(testAUD, testSlice)); ok {
+		t.Error("found dimensions in an access unit without SPS")
+	}
+}
+
+func TestProfileLevel(t *testing.T) {
+	tests := []struct {
+		name                        string
+		data                        []byte
+		profile, constraints, level byte
+		ok                          bool
+	}{
+		{"constrained baseline", annexB(spsBaseline480, testPPS, testIDR), 0x42, 0xc0, 0x1e, true},
+		{"main", annexB(testAUD, spsMain720), 0x4d, 0x40, 0x1f, true},
+		{"high", annexB(spsHigh1080), 0x64, 0x00, 0x28, true},
+		{"no SPS", annexB(testSlice), 0, 0, 0, false},
+		{"short SPS", annexB([]byte{0x67, 0x42}), 0, 0, 0, false},
+	}
+	for _, test := range tests {
+		profile, constraints, level, ok := ProfileLevel(test.data)
+		if profile != test.profile || constraints != test.constraints || level != test.level || ok != test.ok {
+			t.Errorf("%s: got %02x%02x%02x %v, want %02x%02x%02x %v", test.name,
+				profile, constraints, level, ok, test.profile, test.constraints, test.level, test.ok)
+		}
+	}
+}
+
+func FuzzNALUnits(f *testing.F) {
+	f.Add(annexB(spsHigh1080, testPPS, testIDR))
+	f.Add(bytes.Join([][]byte{nil, testAUD, testSlice}, []byte{0, 0, 1}))
+	f.Add([]byte{0, 0, 0, 0, 1, 0, 0, 1, 0})
+	f.Add(testIDR)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		total := 0
+		for _, unit := range NALUnits(data) {
+			if len(unit) == 0 {
+				t.Fatal("empty NAL unit")
+			}
+			if bytes.Contains(unit, startCode) {
+				t.Fatalf("NAL unit %x contains a start code", unit)
+			}
+			total += len(unit)
+		}
+		if total > len(data) {
+			t.Fatalf("NAL units hold %d bytes of %d", total, len(data))
+		}
+
+		// Whatever the units, parsing them must not panic
+		IsKeyframe(data)
+		Dimensions(data)
+		ProfileLevel(data)
+	})
+}
@@ -0,0 +1,173 @@
+package h264
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// DefaultMaxAccessUnitSize bounds the size of an access unit returned by a
+// Reader
+const DefaultMaxAccessUnitSize = 4 * 1024 * 1024
+
+const readSize = 64 * 1024
+
+var ErrAccessUnitTooLarge = errors.New("H.264 access unit too large")
+
+// Reader splits an Annex-B stream into access units. A new access unit starts
+// at an access unit delimiter, at parameter sets or SEI following a picture,
+// or at a slice starting a new picture (first_mb_in_slice of zero).
+//
+// An access unit is only known to be complete once the next one starts, so
+// each is returned when the encoder writes the following picture, one frame
+// interval late.
+type Reader struct {
+	reader            io.Reader
+	MaxAccessUnitSize int
+
+	buffer  []byte
+	scanned int  // Bytes of buffer already searched for a start code
+	synced  bool // buffer starts right after a start code
+	eof     bool
+
+	pending    [][]byte // NAL units of the access unit being collected
+	size       int
+	hasPicture bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		reader:            r,
+		MaxAccessUnitSize: DefaultMaxAccessUnitSize,
+	}
+}
+
+// ReadAccessUnit returns the next access unit in Annex-B with 4 byte start
+// codes. It returns io.EOF at the end of the stream.
+func (r *Reader) ReadAccessUnit() ([]byte, error) {
+	for {
+		unit, err := r.readNALUnit()
+		if err == io.EOF {
+			if r.hasPicture {
+				return r.flush(), nil
+			}
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(unit) == 0 {
+			continue
+		}
+
+		if r.hasPicture && startsAccessUnit(unit) {
+			accessUnit := r.flush()
+			r.add(unit)
+			return accessUnit, nil
+		}
+		r.add(unit)
+		if r.size > r.MaxAccessUnitSize {
+			r.flush()
+			return nil, ErrAccessUnitTooLarge
+		}
+	}
+}
+
+func (r *Reader) add(unit []byte) {
+	r.pending = append(r.pending, unit)
+	r.size += 4 + len(unit)
+	if isPicture(unit) {
+		r.hasPicture = true
+	}
+}
+
+func (r *Reader) flush() []byte {
+	accessUnit := make([]byte, 0, r.size)
+	for _, unit := range r.pending {
+		accessUnit = append(accessUnit, 0, 0, 0, 1)
+		accessUnit = append(accessUnit, unit...)
+	}
+	r.pending = r.pending[:0]
+	r.size = 0
+	r.hasPicture = false
+	return accessUnit
+}
+
+// readNALUnit returns the next NAL unit without its start code, bytes in
+// front of the first start code are discarded.
+func (r *Reader) readNALUnit() ([]byte, error) {
+	for {
+		if index := bytes.Index(r.buffer[r.scanned:], startCode); index >= 0 {
+			end := r.scanned + index
+			var unit []byte
+			if r.synced {
+				unit = bytes.Clone(trimTrailingZeros(r.buffer[:end]))
+			}
+			r.buffer = r.buffer[end+len(startCode):]
+			r.scanned = 0
+			r.synced = true
+			if unit != nil {
+				return unit, nil
+			}
+			continue
+		}
+
+		if r.eof {
+			if !r.synced || len(r.buffer) == 0 {
+				return nil, io.EOF
+			}
+			unit := bytes.Clone(trimTrailingZeros(r.buffer))
+			r.buffer, r.scanned = nil, 0
+			r.synced = false
+			return unit, nil
+		}
+		if len(r.buffer) > r.MaxAccessUnitSize {
+			return nil, ErrAccessUnitTooLarge
+		}
+
+		// The start code might straddle the end of what was read so far
+		r.scanned = max(len(r.buffer)-len(startCode)+1, 0)
+		if err := r.fill(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (r *Reader) fill() error {
+	if cap(r.buffer)-len(r.buffer) < readSize {
+		buffer := make([]byte, len(r.buffer), 2*len(r.buffer)+readSize)
+		copy(buffer, r.buffer)
+		r.buffer = buffer
+	}
+
+	n, err := r.reader.Read(r.buffer[len(r.buffer) : len(r.buffer)+readSize])
+	r.buffer = r.buffer[:len(r.buffer)+n]
+	if err == io.EOF {
+		r.eof = true
+		return nil
+	}
+	return err
+}
+
+// isPicture reports whether a NAL unit carries a slice of a picture.
+func isPicture(unit []byte) bool {
+	switch NALUType(unit) {
+	case NALUTypeSlice, NALUTypeIDR:
+		return true
+	}
+	return false
+}
+
+// startsAccessUnit reports whether a NAL unit following a picture begins the
+// next access unit, see section 7.4.1.2.3 of ITU-T H.264.
+func startsAccessUnit(unit []byte) bool {
+	switch NALUType(unit) {
+	case NALUTypeAUD, NALUTypeSPS, NALUTypePPS, NALUTypeSEI:
+		return true
+	case NALUTypeSlice, NALUTypeIDR:
+		// first_mb_in_slice is the first Exp-Golomb code of the slice
+		// header, zero is coded as a single set bit
+		return len(unit) > 1 && unit[1]&0x80 != 0
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package h264
+
+import "errors"
+
+var errShortSPS = errors.New("SPS ends early")
+
+// Profiles whose SPS carry the chroma format and bit depths
+var highProfiles = map[uint]bool{
+	100: true, 110: true, 122: true, 244: true, 44: true, 83: true,
+	86: true, 118: true, 128: true, 138: true, 139: true, 134: true, 135: true,
+}
+
+// spsDimensions parses the picture size from a sequence parameter set, see
+// section 7.3.2.1.1 of ITU-T H.264. Only the fields in front of the size
+// and the cropping are read.
+func spsDimensions(nalu []byte) (width, height uint16, ok bool) {
+	if len(nalu) < 4 {
+		return 0, 0, false
+	}
+	r := &bitReader{data: unescapeRBSP(nalu[1:])}
+
+	profile := r.bits(8)
+	r.bits(16) // Constraint flags and level
+	r.ue()     // seq_parameter_set_id
+
+	chromaFormat := uint(1)
+	separateColourPlane := false
+	if highProfiles[profile] {
+		chromaFormat = r.ue()
+		if chromaFormat == 3 {
+			separateColourPlane = r.bits(1) == 1
+		}
+		r.ue()    // bit_depth_luma_minus8
+		r.ue()    // bit_depth_chroma_minus8
+		r.bits(1) // qpprime_y_zero_transform_bypass_flag
+		if r.bits(1) == 1 {
+			lists := 8
+			if chromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if r.bits(1) == 0 {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				r.skipScalingList(size)
+			}
+		}
+	}
+
+	r.ue() // log2_max_frame_num_minus4
+	switch r.ue() {
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.bits(1) // delta_pic_order_always_zero_flag
+		r.se()    // offset_for_non_ref_pic
+		r.se()    // offset_for_top_to_bottom_field
+		cycle := r.ue()
+		for i := uint(0); i < cycle && r.err == nil; i++ {
+			r.se()
+		}
+	}
+	r.ue()    // max_num_ref_frames
+	r.bits(1) // gaps_in_frame_num_value_allowed_flag
+
+	widthInMbs := r.ue() + 1
+	heightInMapUnits := r.ue() + 1
+	frameMbsOnly := r.bits(1)
+	if frameMbsOnly == 0 {
+		r.bits(1) // mb_adaptive_frame_field_flag
+	}
+	r.bits(1) // direct_8x8_inference_flag
+
+	var cropLeft, cropRight, cropTop, cropBottom uint
+	if r.bits(1) == 1 {
+		cropLeft, cropRight, cropTop, cropBottom = r.ue(), r.ue(), r.ue(), r.ue()
+	}
+	if r.err != nil {
+		return 0, 0, false
+	}
+
+	// Cropping is expressed in chroma samples
+	cropUnitX, cropUnitY := uint(1), 2-frameMbsOnly
+	if chromaFormat != 0 && !separateColourPlane {
+		if chromaFormat == 1 || chromaFormat == 2 {
+			cropUnitX = 2
+		}
+		if chromaFormat == 1 {
+			cropUnitY *= 2
+		}
+	}
+
+	w := widthInMbs*16 - cropUnitX*(cropLeft+cropRight)
+	h := (2-frameMbsOnly)*heightInMapUnits*16 - cropUnitY*(cropTop+cropBottom)
+	if w == 0 || h == 0 || w > 0xffff || h > 0xffff {
+		return 0, 0, false
+	}
+	return uint16(w), uint16(h), true
+}
+
+// unescapeRBSP removes the emulation prevention bytes, the 0x03 inserted
+// after two zero bytes so the payload never contains a start code.
+func unescapeRBSP(data []byte) []byte {
+	rbsp := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+	return rbsp
+}
+
+// bitReader reads the fields of a parameter set. Reading past the end sets
+// err and returns zeros, so parsers can check once at the end.
+type bitReader struct {
+	data     []byte
+	position int // in bits
+	err      error
+}
+
+func (r *bitReader) bits(n int) uint {
+	var value uint
+	for i := 0; i < n; i++ {
+		if r.position >= len(r.data)*8 {
+			r.err = errShortSPS
+			return 0
+		}
+		bit := r.data[r.position/8] >> (7 - r.position%8) & 1
+		value = value<<1 | uint(bit)
+		r.position++
+	}
+	return value
+}
+
+// ue reads an unsigned Exp-Golomb code.
+func (r *bitReader) ue() uint {
+	zeros := 0
+	for r.bits(1) == 0 {
+		if r.err != nil || zeros > 31 {
+			r.err = errShortSPS
+			return 0
+		}
+		zeros++
+	}
+	return 1<<zeros - 1 + r.bits(zeros)
+}
+
+// se reads a signed Exp-Golomb code.
+func (r *bitReader) se() int {
+	value := r.ue()
+	if value&1 == 1 {
+		return int(value+1) / 2
+	}
+	return -int(value / 2)
+}
+
+func (r *bitReader) skipScalingList(size int) {
+	last, next := 8, 8
+	for i := 0; i < size && r.err == nil; i++ {
+		if next != 0 {
+			next = (last + r.se() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package h264
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// Parameter sets written by encoders in the wild
+var (
+	// x264, High profile 1920x1088 cropped to 1080 lines, with emulation
+	// prevention bytes
+	spsHigh1080 = mustHex("67640028acd940780227e584000003000400000300f03c60c658")
+	// Constrained Baseline 640x480, as browsers and Raspberry Pi cameras send
+	spsBaseline480 = mustHex("6742c01eda0280f69b80808301")
+	// Main profile 1280x720 with VUI
+	spsMain720 = mustHex("674d401fe8802802dd80b501010140000003004000000c83c60c4480")
+)
+
+func mustHex(s string) []byte {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// bitWriter writes the fields of a parameter set for spsFields.
+type bitWriter struct {
+	data  []byte
+	count int // bits written
+}
+
+func (w *bitWriter) bits(value uint, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.count%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		w.data[len(w.data)-1] |= byte(value>>i&1) << (7 - w.count%8)
+		w.count++
+	}
+}
+
+func (w *bitWriter) ue(value uint) {
+	length := 0
+	for v := value + 1; v > 1; v >>= 1 {
+		length++
+	}
+	w.bits(0, length)
+	w.bits(value+1, length+1)
+}
+
+func (w *bitWriter) se(value int) {
+	if value > 0 {
+		w.ue(uint(2*value - 1))
+	} else {
+		w.ue(uint(-2 * value))
+	}
+}
+
+// spsFields are the fields of a sequence parameter set the parser reads.
+type spsFields struct {
+	profile      uint
+	chromaFormat uint
+	// scalingLists enables seq_scaling_matrix_present_flag, with every list
+	// sent: even ones in full, odd ones falling back to the default list
+	scalingLists   bool
+	pocType        uint
+	pocCycle       int
+	widthInMbs     uint
+	heightInUnits  uint
+	interlaced     bool
+	crop           [4]uint // left, right, top, bottom
+	cropEnabled    bool
+	truncateToBits int
+}
+
+// build writes the SPS NAL unit, with emulation prevention.
+func (f spsFields) build() []byte {
+	w := &bitWriter{}
+	w.bits(f.profile, 8)
+	w.bits(0, 8)  // Constraint flags
+	w.bits(40, 8) // Level 4.0
+	w.ue(0)       // seq_parameter_set_id
+
+	if highProfiles[f.profile] {
+		w.ue(f.chromaFormat)
+		if f.chromaFormat == 3 {
+			w.bits(0, 1) // separate_colour_plane_flag
+		}
+		w.ue(0)      // bit_depth_luma_minus8
+		w.ue(0)      // bit_depth_chroma_minus8
+		w.bits(0, 1) // qpprime_y_zero_transform_bypass_flag
+		if f.scalingLists {
+			w.bits(1, 1)
+			lists := 8
+			if f.chromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				w.bits(1, 1)
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				if i%2 == 1 {
+					// A first scale of zero selects the default list
+					w.se(-8)
+					continue
+				}
+				for j := 0; j < size; j++ {
+					// Scales stay around 8, never reaching the zero that
+					// ends a list early
+					w.se([]int{3, -3, 2, -2, 1, -1, 0}[j%7])
+				}
+			}
+		} else {
+			w.bits(0, 1)
+		}
+	}
+
+	w.ue(0) // log2_max_frame_num_minus4
+	w.ue(f.pocType)
+	switch f.pocType {
+	case 0:
+		w.ue(2) // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		w.bits(0, 1) // delta_pic_order_always_zero_flag
+		w.se(-3)     // offset_for_non_ref_pic
+		w.se(5)      // offset_for_top_to_bottom_field
+		w.ue(uint(f.pocCycle))
+		for i := 0; i < f.pocCycle; i++ {
+			w.se(i - 2)
+		}
+	}
+	w.ue(4)      // max_num_ref_frames
+	w.bits(0, 1) // gaps_in_frame_num_value_allowed_flag
+	w.ue(f.widthInMbs - 1)
+	w.ue(f.heightInUnits - 1)
+	if f.interlaced {
+		w.bits(0, 1) // frame_mbs_only_flag
+		w.bits(1, 1) // mb_adaptive_frame_field_flag
+	} else {
+		w.bits(1, 1)
+	}
+	w.bits(1, 1) // direct_8x8_inference_flag
+	if f.cropEnabled {
+		w.bits(1, 1)
+		for _, crop := range f.crop {
+			w.ue(crop)
+		}
+	} else {
+		w.bits(0, 1)
+	}
+	w.bits(0, 1) // vui_parameters_present_flag
+	w.bits(1, 1) // rbsp_stop_one_bit
+	w.bits(0, (8-w.count%8)%8)
+
+	rbsp := w.data
+	if f.truncateToBits > 0 {
+		rbsp = rbsp[:f.truncateToBits/8]
+	}
+	return append([]byte{0x67}, escapeRBSP(rbsp)...)
+}
+
+// escapeRBSP inserts emulation prevention bytes, the reverse of unescapeRBSP.
+func escapeRBSP(rbsp []byte) []byte {
+	var escaped []byte
+	zeros := 0
+	for _, b := range rbsp {
+		if zeros >= 2 && b <= 0x03 {
+			escaped = append(escaped, 0x03)
+			zeros = 0
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		escaped = append(escaped, b)
+	}
+	return escaped
+}
+
+func TestSPSDimensions(t *testing.T) {
+	tests := []struct {
+		name          string
+		sps           []byte
+		width, height uint16
+		ok            bool
+	}{
+		{name: "x264 high 1080p", sps: spsHigh1080, width: 1920, height: 1080, ok: true},
+		{name: "constrained baseline 480p", sps: spsBaseline480, width: 640, height: 480, ok: true},
+		{name: "main 720p", sps: spsMain720, width: 1280, height: 720, ok: true},
+		{
+			name:   "baseline",
+			sps:    spsFields{profile: 66, widthInMbs: 40, heightInUnits: 30}.build(),
+			width:  640,
+			height: 480,
+			ok:     true,
+		},
+		{
+			name:   "high without scaling lists",
+			sps:    spsFields{profile: 100, chromaFormat: 1, widthInMbs: 80, heightInUnits: 45}.build(),
+			width:  1280,
+			height: 720,
+			ok:     true,
+		},
+		{
+			name: "high with scaling lists and cropping",
+			sps: spsFields{
+				profile: 100, chromaFormat: 1, scalingLists: true,
+				widthInMbs: 120, heightInUnits: 68,
+				cropEnabled: true, crop: [4]uint{0, 0, 0, 4},
+			}.build(),
+			width:  1920,
+			height: 1080,
+			ok:     true,
+		},
+		{
+			name: "high 4:4:4 with twelve scaling lists",
+			sps: spsFields{
+				profile: 244, chromaFormat: 3, scalingLists: true,
+				widthInMbs: 40, heightInUnits: 30,
+				// Cropping is in luma samples without chroma subsampling
+				cropEnabled: true, crop: [4]uint{2, 6, 1, 3},
+			}.build(),
+			width:  632,
+			height: 476,
+			ok:     true,
+		},
+		{
+			name: "4:2:2 cropping",
+			sps: spsFields{
+				profile: 122, chromaFormat: 2,
+				widthInMbs: 40, heightInUnits: 30,
+				cropEnabled: true, crop: [4]uint{1, 1, 1, 1},
+			}.build(),
+			width:  636,
+			height: 478,
+			ok:     true,
+		},
+		{
+			name: "interlaced with cropping",
+			sps: spsFields{
+				profile: 77, interlaced: true,
+				widthInMbs: 45, heightInUnits: 18,
+				cropEnabled: true, crop: [4]uint{0, 0, 0, 2},
+			}.build(),
+			width:  720,
+			height: 568,
+			ok:     true,
+		},
+		{
+			name: "picture order count type 1",
+			sps: spsFields{
+				profile: 66, pocType: 1, pocCycle: 5,
+				widthInMbs: 20, heightInUnits: 15,
+			}.build(),
+			width:  320,
+			height: 240,
+			ok:     true,
+		},
+		{
+			name:  "picture order count type 2",
+			sps:   spsFields{profile: 66, pocType: 2, widthInMbs: 20, heightInUnits: 15}.build(),
+			width: 320, height: 240, ok: true,
+		},
+		{
+			name: "cropped to nothing",
+			sps: spsFields{
+				profile: 66, widthInMbs: 1, heightInUnits: 1,
+				cropEnabled: true, crop: [4]uint{4, 4, 0, 0},
+			}.build(),
+		},
+		{
+			name: "truncated in the scaling lists",
+			sps: spsFields{
+				profile: 100, chromaFormat: 1, scalingLists: true,
+				widthInMbs: 80, heightInUnits: 45, truncateToBits: 64,
+			}.build(),
+		},
+		{name: "truncated x264", sps: spsHigh1080[:8]},
+		{name: "header only", sps: spsHigh1080[:4]},
+		{name: "too short", sps: []byte{0x67, 0x42}},
+		{name: "empty"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			width, height, ok := spsDimensions(test.sps)
+			if width != test.width || height != test.height || ok != test.ok {
+				t.Errorf("got %dx%d %v, want %dx%d %v", width, height, ok, test.width, test.height, test.ok)
+			}
+		})
+	}
+}
+
+func TestUnescapeRBSP(t *testing.T) {
+	tests := []struct {
+		escaped, rbsp string
+	}{
+		{"0000030100", "00000100"},
+		{"00000303", "000003"},
+		{"000003000003", "00000000"},
+		{"0003", "0003"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if rbsp := hex.EncodeToString(unescapeRBSP(mustHex(test.escaped))); rbsp != test.rbsp {
+			t.Errorf("unescapeRBSP(%s) = %s, want %s", test.escaped, rbsp, test.rbsp)
+		}
+	}
+}
+
+func FuzzSPSDimensions(f *testing.F) {
+	f.Add(spsHigh1080)
+	f.Add(spsBaseline480)
+	f.Add(spsMain720)
+	f.Add(spsFields{profile: 244, chromaFormat: 3, scalingLists: true, widthInMbs: 40, heightInUnits: 30}.build())
+	f.Add(spsFields{profile: 66, pocType: 1, pocCycle: 3, widthInMbs: 20, heightInUnits: 15}.build())
+
+	f.Fuzz(func(t *testing.T, sps []byte) {
+		width, height, ok := spsDimensions(sps)
+		if ok != (width > 0 && height > 0) {
+			t.Errorf("got %dx%d %v", width, height, ok)
+		}
+	})
+}
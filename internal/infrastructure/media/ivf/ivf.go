@@ -0,0 +1,103 @@
+// Package ivf reads and writes IVF, the minimal container ffmpeg and libvpx
+// use to store VP8 frames. ffmpeg also reads H.264 from it, with Annex-B
+// access units as frames. See https://wiki.multimedia.cx/index.php/IVF
+package ivf
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	FileHeaderSize  = 32
+	FrameHeaderSize = 12
+
+	// DefaultMaxFrameSize bounds the frame size accepted by a Reader
+	DefaultMaxFrameSize = 4 * 1024 * 1024
+
+	FourCCVP8  = "VP80"
+	FourCCVP9  = "VP90"
+	FourCCAV1  = "AV01"
+	FourCCH264 = "H264"
+
+	signature = "DKIF"
+)
+
+var (
+	ErrSignatureMismatch = errors.New("IVF signature mismatch")
+	ErrInvalidHeader     = errors.New("invalid IVF header")
+	ErrLostSync          = errors.New("IVF stream lost sync")
+
+	errImplausiblePayload = errors.New("implausible IVF payload")
+)
+
+// FileHeader holds the fields of the 32 byte IVF file header.
+type FileHeader struct {
+	FourCC string
+	Width  uint16
+	Height uint16
+	// Timestamps are expressed in TimebaseNumerator/TimebaseDenominator
+	// seconds, e.g. 1/30 or 1/1000.
+	TimebaseNumerator   uint32
+	TimebaseDenominator uint32
+	NumFrames           uint32
+}
+
+// Frame is a single frame payload with its presentation timestamp.
+type Frame struct {
+	Timestamp uint64        // in timebase units
+	PTS       time.Duration // Timestamp converted to a duration
+	Payload   []byte
+}
+
+// PTS converts a timestamp in timebase units to a duration.
+func (h FileHeader) PTS(timestamp uint64) time.Duration {
+	if h.TimebaseDenominator == 0 {
+		return 0
+	}
+
+	// Split whole and fractional seconds so long streams do not overflow
+	denominator, numerator := uint64(h.TimebaseDenominator), uint64(h.TimebaseNumerator)
+	whole, remainder := timestamp/denominator, timestamp%denominator
+	return time.Duration(whole*numerator)*time.Second +
+		time.Duration(remainder*numerator*uint64(time.Second)/denominator)
+}
+
+// Timestamp converts a duration to a timestamp in timebase units.
+func (h FileHeader) Timestamp(pts time.Duration) uint64 {
+	if h.TimebaseNumerator == 0 || pts < 0 {
+		return 0
+	}
+	ticks := pts.Seconds() * float64(h.TimebaseDenominator) / float64(h.TimebaseNumerator)
+	return uint64(math.Round(ticks))
+}
+
+// FourCC returns the IVF codec of a video mime type, e.g. VP80 for video/VP8,
+// or an empty string when IVF cannot carry it.
+func FourCC(mimeType string) string {
+	for fourCC, codec := range mimeTypes {
+		if strings.EqualFold(codec, mimeType) {
+			return fourCC
+		}
+	}
+	return ""
+}
+
+// MimeType returns the mime type of an IVF codec, or an empty string when it
+// is unknown.
+func MimeType(fourCC string) string {
+	return mimeTypes[fourCC]
+}
+
+var mimeTypes = map[string]string{
+	FourCCVP8:  "video/VP8",
+	FourCCVP9:  "video/VP9",
+	FourCCAV1:  "video/AV1",
+	FourCCH264: "video/H264",
+}
+
+func (h FileHeader) valid() bool {
+	return len(h.FourCC) == 4 && h.TimebaseNumerator != 0 && h.TimebaseDenominator != 0
+}
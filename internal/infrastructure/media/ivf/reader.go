@@ -0,0 +1,209 @@
+package ivf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// maxTimestampGap is the largest jump from the last frame accepted for a
+// header found while resynchronising, anything bigger is taken for garbage.
+const maxTimestampGap = 10 * time.Second
+
+// Reader parses an IVF stream. When a frame header is implausible (bad size,
+// payload not matching the codec) the reader scans forward byte by byte until
+// it finds the next plausible frame instead of desynchronising the rest of
+// the stream.
+//
+// Right after a frame the next header is where the stream says it is, so its
+// timestamp is taken as it is: recordings legitimately pause or restart their
+// clock. Empty frames, which carry nothing to decode, are skipped there. Only
+// headers found while scanning must have a payload and a timestamp following
+// the last frame within maxTimestampGap, random bytes rarely do.
+type Reader struct {
+	reader       *bufio.Reader
+	header       FileHeader
+	MaxFrameSize uint32
+	// MaxResyncBytes bounds how far the reader scans for the next frame
+	// before giving up with ErrLostSync.
+	MaxResyncBytes int64
+
+	maxGap        uint64
+	lastTimestamp uint64
+	started       bool
+	skipped       int64
+}
+
+// NewReader reads the file header from r and returns a Reader positioned at
+// the first frame.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{
+		reader:         bufio.NewReaderSize(r, 64*1024),
+		MaxFrameSize:   DefaultMaxFrameSize,
+		MaxResyncBytes: DefaultMaxFrameSize,
+	}
+
+	header, err := reader.readFileHeader()
+	if err != nil {
+		return nil, err
+	}
+	reader.header = header
+	reader.maxGap = header.Timestamp(maxTimestampGap)
+
+	return reader, nil
+}
+
+func (r *Reader) Header() FileHeader {
+	return r.header
+}
+
+// Skipped returns the number of bytes discarded while resynchronising.
+func (r *Reader) Skipped() int64 {
+	return r.skipped
+}
+
+// ReadFrame returns the next frame. It returns io.EOF at the end of the
+// stream and io.ErrUnexpectedEOF when the stream ends mid-frame.
+func (r *Reader) ReadFrame() (Frame, error) {
+	var scanned int64
+	for {
+		frameHeader, err := r.reader.Peek(FrameHeaderSize)
+		if err != nil {
+			if err == io.EOF && len(frameHeader) > 0 {
+				return Frame{}, io.ErrUnexpectedEOF
+			}
+			return Frame{}, err
+		}
+
+		size := binary.LittleEndian.Uint32(frameHeader[0:4])
+		timestamp := binary.LittleEndian.Uint64(frameHeader[4:12])
+		resyncing := scanned > 0
+		if size == 0 && !resyncing {
+			if _, err := r.reader.Discard(FrameHeaderSize); err != nil {
+				return Frame{}, err
+			}
+			continue
+		}
+		if r.plausible(size, timestamp, resyncing) {
+			frame, err := r.readPayload(size, timestamp)
+			if err != errImplausiblePayload {
+				return frame, err
+			}
+		}
+
+		// Corrupted header, slide one byte and look for the next frame
+		if _, err := r.reader.Discard(1); err != nil {
+			return Frame{}, err
+		}
+		r.skipped++
+		scanned++
+		if scanned > r.MaxResyncBytes {
+			return Frame{}, ErrLostSync
+		}
+	}
+}
+
+func (r *Reader) plausible(size uint32, timestamp uint64, resyncing bool) bool {
+	if size == 0 || size > r.MaxFrameSize {
+		return false
+	}
+	if resyncing && r.started && (timestamp < r.lastTimestamp || timestamp-r.lastTimestamp > r.maxGap) {
+		return false
+	}
+	return true
+}
+
+func (r *Reader) readPayload(size uint32, timestamp uint64) (Frame, error) {
+	// Check the start of the payload before consuming anything, so a bad
+	// guess can still be skipped over
+	peekSize := FrameHeaderSize + min(int(size), 10)
+	peeked, err := r.reader.Peek(peekSize)
+	if err != nil && len(peeked) < peekSize {
+		if err == io.EOF {
+			return Frame{}, io.ErrUnexpectedEOF
+		}
+		return Frame{}, err
+	}
+	if !plausiblePayload(r.header.FourCC, peeked[FrameHeaderSize:], size) {
+		return Frame{}, errImplausiblePayload
+	}
+
+	if _, err := r.reader.Discard(FrameHeaderSize); err != nil {
+		return Frame{}, err
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r.reader, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Frame{}, err
+	}
+
+	r.started = true
+	r.lastTimestamp = timestamp
+	return Frame{
+		Timestamp: timestamp,
+		PTS:       r.header.PTS(timestamp),
+		Payload:   payload,
+	}, nil
+}
+
+// plausiblePayload checks the codec specific start of a frame. Only VP8 has
+// a cheap check, other codecs are accepted as is.
+func plausiblePayload(fourCC string, start []byte, size uint32) bool {
+	if fourCC != FourCCVP8 {
+		return true
+	}
+	if len(start) < 3 {
+		return false
+	}
+
+	// 3 byte frame tag: keyframe flag, version, show frame and the size of
+	// the first partition which has to fit in the frame
+	tag := uint32(start[0]) | uint32(start[1])<<8 | uint32(start[2])<<16
+	version := (tag >> 1) & 0x7
+	firstPartitionSize := tag >> 5
+	if version > 3 || firstPartitionSize >= size {
+		return false
+	}
+
+	// Keyframes carry a start code after the tag
+	if tag&0x1 == 0 {
+		return len(start) >= 6 && start[3] == 0x9d && start[4] == 0x01 && start[5] == 0x2a
+	}
+	return true
+}
+
+func (r *Reader) readFileHeader() (FileHeader, error) {
+	buffer := make([]byte, FileHeaderSize)
+	if _, err := io.ReadFull(r.reader, buffer); err != nil {
+		return FileHeader{}, err
+	}
+
+	if string(buffer[0:4]) != signature {
+		return FileHeader{}, ErrSignatureMismatch
+	}
+
+	headerSize := binary.LittleEndian.Uint16(buffer[6:8])
+	header := FileHeader{
+		FourCC:              string(buffer[8:12]),
+		Width:               binary.LittleEndian.Uint16(buffer[12:14]),
+		Height:              binary.LittleEndian.Uint16(buffer[14:16]),
+		TimebaseDenominator: binary.LittleEndian.Uint32(buffer[16:20]),
+		TimebaseNumerator:   binary.LittleEndian.Uint32(buffer[20:24]),
+		NumFrames:           binary.LittleEndian.Uint32(buffer[24:28]),
+	}
+	if !header.valid() || headerSize < FileHeaderSize {
+		return FileHeader{}, ErrInvalidHeader
+	}
+
+	// Skip any header extension
+	if headerSize > FileHeaderSize {
+		if _, err := r.reader.Discard(int(headerSize - FileHeaderSize)); err != nil {
+			return FileHeader{}, err
+		}
+	}
+
+	return header, nil
+}
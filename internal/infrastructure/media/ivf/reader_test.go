@@ -0,0 +1,358 @@
+package ivf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+var testHeader = FileHeader{
+	FourCC:              FourCCVP8,
+	Width:               640,
+	Height:              480,
+	TimebaseNumerator:   1,
+	TimebaseDenominator: 1000,
+}
+
+// vp8Frame returns a payload passing the VP8 checks of the reader.
+func vp8Frame(keyframe bool, size int, fill byte) []byte {
+	payload := bytes.Repeat([]byte{fill}, size)
+	// Version 0, shown, first partition of 4 bytes
+	tag := uint32(1<<4 | 4<<5)
+	if !keyframe {
+		tag |= 1
+	}
+	payload[0], payload[1], payload[2] = byte(tag), byte(tag>>8), byte(tag>>16)
+	if keyframe {
+		payload[3], payload[4], payload[5] = 0x9d, 0x01, 0x2a
+	}
+	return payload
+}
+
+func testFrames() []Frame {
+	return []Frame{
+		{PTS: 0, Payload: vp8Frame(true, 40, 0x11)},
+		{PTS: 33 * time.Millisecond, Payload: vp8Frame(false, 20, 0x22)},
+		{PTS: 66 * time.Millisecond, Payload: vp8Frame(false, 25, 0x33)},
+		{PTS: 100 * time.Millisecond, Payload: vp8Frame(true, 30, 0x44)},
+	}
+}
+
+func encode(t testing.TB, header FileHeader, frames []Frame) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	writer, err := NewWriter(&buffer, header)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	for _, frame := range frames {
+		if err := writer.WriteFrame(frame); err != nil {
+			t.Fatalf("WriteFrame: %v", err)
+		}
+	}
+	return buffer.Bytes()
+}
+
+// readAll reads frames until the first error, which is returned as well.
+func readAll(t *testing.T, data []byte, setup func(*Reader)) (*Reader, []Frame, error) {
+	t.Helper()
+
+	reader, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if setup != nil {
+		setup(reader)
+	}
+
+	var frames []Frame
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			return reader, frames, err
+		}
+		frames = append(frames, frame)
+	}
+}
+
+func checkFrames(t *testing.T, got []Frame, want []Frame) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("read %d frames, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].PTS != want[i].PTS {
+			t.Errorf("frame %d: PTS %v, want %v", i, got[i].PTS, want[i].PTS)
+		}
+		if !bytes.Equal(got[i].Payload, want[i].Payload) {
+			t.Errorf("frame %d: payload differs", i)
+		}
+	}
+}
+
+// withFrameHeader inserts a raw frame header and payload after the given
+// number of frames of an encoded stream.
+func withFrameHeader(t *testing.T, data []byte, after int, size uint32, timestamp uint64, payload []byte) []byte {
+	t.Helper()
+
+	offset := FileHeaderSize
+	for i := 0; i < after; i++ {
+		offset += FrameHeaderSize + int(binary.LittleEndian.Uint32(data[offset:]))
+	}
+	inserted := make([]byte, FrameHeaderSize, FrameHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(inserted[0:4], size)
+	binary.LittleEndian.PutUint64(inserted[4:12], timestamp)
+	inserted = append(inserted, payload...)
+
+	return append(append(bytes.Clone(data[:offset]), inserted...), data[offset:]...)
+}
+
+func TestRoundTrip(t *testing.T) {
+	frames := testFrames()
+	reader, got, err := readAll(t, encode(t, testHeader, frames), nil)
+	if err != io.EOF {
+		t.Fatalf("ReadFrame: %v, want io.EOF", err)
+	}
+
+	header := reader.Header()
+	if header.FourCC != FourCCVP8 || header.Width != 640 || header.Height != 480 {
+		t.Errorf("header %s %dx%d, want %s 640x480", header.FourCC, header.Width, header.Height, FourCCVP8)
+	}
+	if header.TimebaseNumerator != 1 || header.TimebaseDenominator != 1000 {
+		t.Errorf("timebase %d/%d, want 1/1000", header.TimebaseNumerator, header.TimebaseDenominator)
+	}
+	for i, frame := range got {
+		if want := uint64(frames[i].PTS / time.Millisecond); frame.Timestamp != want {
+			t.Errorf("frame %d: timestamp %d, want %d", i, frame.Timestamp, want)
+		}
+	}
+	checkFrames(t, got, frames)
+	if reader.Skipped() != 0 {
+		t.Errorf("skipped %d bytes of a clean stream", reader.Skipped())
+	}
+}
+
+func TestHeaderErrors(t *testing.T) {
+	data := encode(t, testHeader, nil)
+
+	bad := bytes.Clone(data)
+	copy(bad, "RIFF")
+	if _, err := NewReader(bytes.NewReader(bad)); err != ErrSignatureMismatch {
+		t.Errorf("bad signature: %v, want ErrSignatureMismatch", err)
+	}
+
+	bad = bytes.Clone(data)
+	binary.LittleEndian.PutUint32(bad[16:20], 0)
+	if _, err := NewReader(bytes.NewReader(bad)); err != ErrInvalidHeader {
+		t.Errorf("zero timebase: %v, want ErrInvalidHeader", err)
+	}
+
+	if _, err := NewReader(bytes.NewReader(data[:20])); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated header: %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestResync(t *testing.T) {
+	frames := testFrames()
+	clean := encode(t, testHeader, frames)
+	secondFrame := FileHeaderSize + FrameHeaderSize + len(frames[0].Payload)
+
+	corruptedSize := bytes.Clone(clean)
+	binary.LittleEndian.PutUint32(corruptedSize[secondFrame:], 0xffffffff)
+
+	corruptedPayload := bytes.Clone(clean)
+	copy(corruptedPayload[secondFrame+FrameHeaderSize:], []byte{0xff, 0xff, 0xff})
+
+	garbage := bytes.Repeat([]byte{0xff}, 50)
+	prefixed := append(append(bytes.Clone(clean[:FileHeaderSize]), garbage...), clean[FileHeaderSize:]...)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []Frame
+		err     error
+		skipped bool
+	}{
+		{
+			name:    "corrupted size",
+			data:    corruptedSize,
+			want:    []Frame{frames[0], frames[2], frames[3]},
+			err:     io.EOF,
+			skipped: true,
+		},
+		{
+			name:    "corrupted payload",
+			data:    corruptedPayload,
+			want:    []Frame{frames[0], frames[2], frames[3]},
+			err:     io.EOF,
+			skipped: true,
+		},
+		{
+			name:    "garbage prefix",
+			data:    prefixed,
+			want:    frames,
+			err:     io.EOF,
+			skipped: true,
+		},
+		{
+			name: "truncated payload",
+			data: clean[:len(clean)-5],
+			want: frames[:3],
+			err:  io.ErrUnexpectedEOF,
+		},
+		{
+			name: "truncated frame header",
+			data: clean[:secondFrame+5],
+			want: frames[:1],
+			err:  io.ErrUnexpectedEOF,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader, got, err := readAll(t, test.data, nil)
+			if err != test.err {
+				t.Fatalf("ReadFrame: %v, want %v", err, test.err)
+			}
+			checkFrames(t, got, test.want)
+			if skipped := reader.Skipped() > 0; skipped != test.skipped {
+				t.Errorf("skipped %d bytes", reader.Skipped())
+			}
+		})
+	}
+
+	t.Run("garbage prefix length", func(t *testing.T) {
+		reader, _, _ := readAll(t, prefixed, nil)
+		if reader.Skipped() != int64(len(garbage)) {
+			t.Errorf("skipped %d bytes, want %d", reader.Skipped(), len(garbage))
+		}
+	})
+}
+
+func TestLostSync(t *testing.T) {
+	frames := testFrames()
+	clean := encode(t, testHeader, frames)
+	garbage := bytes.Repeat([]byte{0xff}, 100)
+	data := append(append(bytes.Clone(clean[:FileHeaderSize]), garbage...), clean[FileHeaderSize:]...)
+
+	reader, got, err := readAll(t, data, func(reader *Reader) {
+		reader.MaxResyncBytes = 16
+	})
+	if !errors.Is(err, ErrLostSync) {
+		t.Fatalf("ReadFrame: %v, want ErrLostSync", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("read %d frames before losing sync", len(got))
+	}
+	if reader.Skipped() != 17 {
+		t.Errorf("skipped %d bytes, want 17", reader.Skipped())
+	}
+}
+
+// Empty frames and timestamp jumps following a frame are valid IVF, they
+// must not be mistaken for corruption and scanned away.
+func TestFrameBoundaries(t *testing.T) {
+	frames := testFrames()
+	clean := encode(t, testHeader, frames)
+
+	t.Run("empty frame", func(t *testing.T) {
+		data := withFrameHeader(t, clean, 2, 0, 50, nil)
+		reader, got, err := readAll(t, data, nil)
+		if err != io.EOF {
+			t.Fatalf("ReadFrame: %v, want io.EOF", err)
+		}
+		checkFrames(t, got, frames)
+		if reader.Skipped() != 0 {
+			t.Errorf("skipped %d bytes", reader.Skipped())
+		}
+	})
+
+	t.Run("timestamp gap", func(t *testing.T) {
+		paused := []Frame{
+			frames[0],
+			{PTS: time.Minute, Payload: frames[1].Payload},
+			{PTS: time.Minute + 33*time.Millisecond, Payload: frames[2].Payload},
+		}
+		reader, got, err := readAll(t, encode(t, testHeader, paused), nil)
+		if err != io.EOF {
+			t.Fatalf("ReadFrame: %v, want io.EOF", err)
+		}
+		checkFrames(t, got, paused)
+		if reader.Skipped() != 0 {
+			t.Errorf("skipped %d bytes", reader.Skipped())
+		}
+	})
+
+	t.Run("timestamp restart", func(t *testing.T) {
+		restarted := []Frame{
+			frames[0],
+			frames[1],
+			{PTS: 0, Payload: frames[2].Payload},
+			{PTS: 33 * time.Millisecond, Payload: frames[3].Payload},
+		}
+		_, got, err := readAll(t, encode(t, testHeader, restarted), nil)
+		if err != io.EOF {
+			t.Fatalf("ReadFrame: %v, want io.EOF", err)
+		}
+		checkFrames(t, got, restarted)
+	})
+
+	t.Run("gap while resyncing", func(t *testing.T) {
+		// A plausible frame far in the future behind garbage is skipped
+		future := vp8Frame(false, 20, 0x55)
+		data := withFrameHeader(t, clean, 1, uint32(len(future)), uint64(time.Hour/time.Millisecond), future)
+		data = withFrameHeader(t, data, 1, 0xffffffff, 0, nil)
+		reader, got, err := readAll(t, data, nil)
+		if err != io.EOF {
+			t.Fatalf("ReadFrame: %v, want io.EOF", err)
+		}
+		checkFrames(t, got, frames)
+		if want := int64(2*FrameHeaderSize + len(future)); reader.Skipped() != want {
+			t.Errorf("skipped %d bytes, want %d", reader.Skipped(), want)
+		}
+	})
+}
+
+func FuzzReader(f *testing.F) {
+	frames := testFrames()
+	clean := encode(f, testHeader, frames)
+	secondFrame := FileHeaderSize + FrameHeaderSize + len(frames[0].Payload)
+
+	corrupted := bytes.Clone(clean)
+	binary.LittleEndian.PutUint32(corrupted[secondFrame:], 0xffffffff)
+	prefixed := append(append(bytes.Clone(clean[:FileHeaderSize]), bytes.Repeat([]byte{0xff}, 50)...), clean[FileHeaderSize:]...)
+	h264 := testHeader
+	h264.FourCC = FourCCH264
+
+	f.Add(clean)
+	f.Add(corrupted)
+	f.Add(prefixed)
+	f.Add(clean[:len(clean)-5])
+	f.Add(clean[:secondFrame+5])
+	f.Add(encode(f, h264, []Frame{{Payload: []byte{0, 0, 0, 1, 0x65, 0x88}}}))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader, err := NewReader(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		reader.MaxFrameSize = 64 * 1024
+
+		for {
+			frame, err := reader.ReadFrame()
+			if err != nil {
+				break
+			}
+			if len(frame.Payload) == 0 || len(frame.Payload) > int(reader.MaxFrameSize) {
+				t.Fatalf("frame of %d bytes", len(frame.Payload))
+			}
+		}
+		if reader.Skipped() > int64(len(data)) {
+			t.Fatalf("skipped %d bytes of %d", reader.Skipped(), len(data))
+		}
+	})
+}
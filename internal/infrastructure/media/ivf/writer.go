@@ -0,0 +1,87 @@
+package ivf
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Writer writes frames to an IVF stream.
+type Writer struct {
+	writer      io.Writer
+	header      FileHeader
+	frameHeader [FrameHeaderSize]byte
+}
+
+// NewWriter writes the file header to w and returns a Writer for its frames.
+func NewWriter(w io.Writer, header FileHeader) (*Writer, error) {
+	if !header.valid() {
+		return nil, ErrInvalidHeader
+	}
+
+	writer := &Writer{
+		writer: w,
+		header: header,
+	}
+	if _, err := w.Write(writer.encodeFileHeader()); err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+func (w *Writer) Header() FileHeader {
+	return w.header
+}
+
+// WriteFrame appends a frame stamped with its PTS in the writer's timebase.
+func (w *Writer) WriteFrame(frame Frame) error {
+	timestamp := w.header.Timestamp(frame.PTS)
+
+	binary.LittleEndian.PutUint32(w.frameHeader[0:4], uint32(len(frame.Payload)))
+	binary.LittleEndian.PutUint64(w.frameHeader[4:12], timestamp)
+	if _, err := w.writer.Write(w.frameHeader[:]); err != nil {
+		return err
+	}
+	if _, err := w.writer.Write(frame.Payload); err != nil {
+		return err
+	}
+
+	w.header.NumFrames++
+	return nil
+}
+
+// Close rewrites the frame count in the file header when the underlying
+// writer can seek, streams are left as they are.
+func (w *Writer) Close() error {
+	seeker, ok := w.writer.(io.WriteSeeker)
+	if !ok {
+		return nil
+	}
+
+	current, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := seeker.Seek(24, io.SeekStart); err != nil {
+		return err
+	}
+	if err := binary.Write(seeker, binary.LittleEndian, w.header.NumFrames); err != nil {
+		return err
+	}
+	_, err = seeker.Seek(current, io.SeekStart)
+	return err
+}
+
+func (w *Writer) encodeFileHeader() []byte {
+	buffer := make([]byte, FileHeaderSize)
+	copy(buffer[0:4], signature)
+	binary.LittleEndian.PutUint16(buffer[4:6], 0)
+	binary.LittleEndian.PutUint16(buffer[6:8], FileHeaderSize)
+	copy(buffer[8:12], w.header.FourCC)
+	binary.LittleEndian.PutUint16(buffer[12:14], w.header.Width)
+	binary.LittleEndian.PutUint16(buffer[14:16], w.header.Height)
+	binary.LittleEndian.PutUint32(buffer[16:20], w.header.TimebaseDenominator)
+	binary.LittleEndian.PutUint32(buffer[20:24], w.header.TimebaseNumerator)
+	binary.LittleEndian.PutUint32(buffer[24:28], w.header.NumFrames)
+	return buffer
+}
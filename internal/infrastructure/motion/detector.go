@@ -0,0 +1,221 @@
+// Package motion detects motion in decoded grayscale frames by comparing each
+// frame with the previous one, entirely on the CPU.
+package motion
+
+import (
+	"fmt"
+	"katkam/internal/config"
+	"sync"
+	"time"
+)
+
+const (
+	EventStarted = "motion_started"
+	EventEnded   = "motion_ended"
+
+	// maxEvents bounds the events kept for the events API
+	maxEvents = 1000
+)
+
+// Event marks the start or end of a period with motion. Score is the share of
+// changed pixels in percent, for ended events the peak of the period.
+type Event struct {
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	Score float64   `json:"score"`
+}
+
+// Detector analyses frames of Config.Width by Config.Height luma bytes. A
+// frame has motion when more than MinArea percent of the unmasked pixels
+// changed by more than Threshold, motion starts after MinFrames such frames
+// in a row and ends after Cooldown seconds without any.
+type Detector struct {
+	Config config.Motion
+
+	mask     []bool // true for ignored pixels
+	unmasked int
+
+	handlers []func(Event)
+
+	mutex        sync.RWMutex
+	previous     []byte
+	streak       int
+	active       bool
+	lastMotion   time.Time
+	peak         float64
+	score        float64
+	lastAnalysed time.Time
+	events       []Event
+}
+
+func NewDetector(cfg config.Motion) *Detector {
+	d := &Detector{
+		Config: cfg,
+		mask:   make([]bool, cfg.Width*cfg.Height),
+	}
+
+	for _, region := range cfg.Masks {
+		left := int(region.X * float64(cfg.Width))
+		top := int(region.Y * float64(cfg.Height))
+		right := int((region.X + region.Width) * float64(cfg.Width))
+		bottom := int((region.Y + region.Height) * float64(cfg.Height))
+		for y := top; y < bottom && y < cfg.Height; y++ {
+			for x := left; x < right && x < cfg.Width; x++ {
+				d.mask[y*cfg.Width+x] = true
+			}
+		}
+	}
+	for _, masked := range d.mask {
+		if !masked {
+			d.unmasked++
+		}
+	}
+
+	return d
+}
+
+// AddEventHandler calls handler for every event. Handlers run on the
+// analysing goroutine and must not block, they must be added before frames
+// are analysed.
+func (d *Detector) AddEventHandler(handler func(Event)) {
+	d.handlers = append(d.handlers, handler)
+}
+
+// FrameSize is the number of bytes of a frame passed to Analyze.
+func (d *Detector) FrameSize() int {
+	return d.Config.Width * d.Config.Height
+}
+
+// Analyze compares frame with the previous one and emits events when motion
+// starts or ends.
+func (d *Detector) Analyze(frame []byte, at time.Time) {
+	if len(frame) != d.FrameSize() {
+		return
+	}
+
+	d.mutex.Lock()
+	score := d.difference(frame)
+	d.previous = append(d.previous[:0], frame...)
+	d.score = score
+	d.lastAnalysed = at
+
+	var event *Event
+	if score >= d.Config.MinArea {
+		d.streak++
+		d.lastMotion = at
+		d.peak = max(d.peak, score)
+		if !d.active && d.streak >= d.Config.MinFrames {
+			d.active = true
+			event = &Event{Type: EventStarted, Time: at, Score: score}
+		}
+	} else {
+		d.streak = 0
+		if d.active && at.Sub(d.lastMotion) >= time.Duration(d.Config.Cooldown)*time.Second {
+			event = d.endLocked(at)
+		}
+	}
+	d.mutex.Unlock()
+
+	if event != nil {
+		d.emit(*event)
+	}
+}
+
+// Reset forgets the previous frame, e.g. when the source restarted, and ends
+// ongoing motion.
+func (d *Detector) Reset() {
+	d.mutex.Lock()
+	d.previous = d.previous[:0]
+	d.streak = 0
+	d.score = 0
+	var event *Event
+	if d.active {
+		event = d.endLocked(time.Now())
+	}
+	d.mutex.Unlock()
+
+	if event != nil {
+		d.emit(*event)
+	}
+}
+
+// difference returns the percentage of unmasked pixels that changed by more
+// than the threshold. Must be called with mutex held.
+func (d *Detector) difference(frame []byte) float64 {
+	if len(d.previous) != len(frame) || d.unmasked == 0 {
+		return 0
+	}
+
+	threshold := d.Config.Threshold
+	changed := 0
+	for i, value := range frame {
+		if d.mask[i] {
+			continue
+		}
+		delta := int(value) - int(d.previous[i])
+		if delta > threshold || delta < -threshold {
+			changed++
+		}
+	}
+	return float64(changed) * 100 / float64(d.unmasked)
+}
+
+func (d *Detector) endLocked(at time.Time) *Event {
+	event := &Event{Type: EventEnded, Time: at, Score: d.peak}
+	d.active = false
+	d.peak = 0
+	return event
+}
+
+func (d *Detector) emit(event Event) {
+	d.mutex.Lock()
+	d.events = append(d.events, event)
+	if len(d.events) > maxEvents {
+		d.events = append(d.events[:0], d.events[len(d.events)-maxEvents:]...)
+	}
+	d.mutex.Unlock()
+
+	if event.Type == EventStarted {
+		fmt.Printf("🏃 Motion started (%.1f%% changed)\n", event.Score)
+	} else {
+		fmt.Printf("🧍 Motion ended (peak %.1f%% changed)\n", event.Score)
+	}
+	for _, handler := range d.handlers {
+		handler(event)
+	}
+}
+
+// Events returns the recorded events between from and to, oldest first.
+func (d *Detector) Events(from, to time.Time) []Event {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	events := []Event{}
+	for _, event := range d.events {
+		if !event.Time.Before(from) && event.Time.Before(to) {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Active reports whether motion is ongoing.
+func (d *Detector) Active() bool {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.active
+}
+
+// Status reports the detector in the status API.
+func (d *Detector) Status() map[string]interface{} {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return map[string]interface{}{
+		"motion": map[string]interface{}{
+			"active":        d.active,
+			"score":         d.score,
+			"last_analysed": d.lastAnalysed,
+		},
+	}
+}
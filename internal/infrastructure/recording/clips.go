@@ -0,0 +1,531 @@
+package recording
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"katkam/internal/config"
+	"katkam/internal/infrastructure/connectivity"
+	"katkam/internal/infrastructure/motion"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	TriggerMotion = "motion"
+	TriggerManual = "manual"
+
+	ThumbnailExtension = ".jpg"
+
+	// clipBatch is how many buffered frames are read at once
+	clipBatch = 64
+	// clipPoll is how long a clip waits for new frames once it reached the
+	// live end of the buffer
+	clipPoll = 50 * time.Millisecond
+
+	thumbnailWidth   = 320
+	thumbnailTimeout = 10 * time.Second
+	clipCleanup      = time.Hour
+)
+
+var (
+	ErrClipNotFound    = errors.New("clip not found")
+	ErrNothingBuffered = errors.New("nothing is buffered to clip from")
+)
+
+// Clip is the sidecar metadata of an event clip.
+type Clip struct {
+	ID       string    `json:"id"`
+	Trigger  string    `json:"trigger"` // motion or manual
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration float64   `json:"duration"` // seconds
+	// PeakScore is the highest motion score seen during the clip, in percent
+	// of changed pixels
+	PeakScore float64 `json:"peak_score"`
+	HasAudio  bool    `json:"has_audio"`
+	Size      int64   `json:"size"` // bytes, of the video and audio files
+	Thumbnail bool    `json:"thumbnail"`
+	// Complete is false while the clip is being written, or when it could
+	// not be finished
+	Complete bool `json:"complete"`
+}
+
+// VideoPath returns the path of the clip's video file in directory.
+func (c Clip) VideoPath(directory string) string {
+	return filepath.Join(directory, c.ID+VideoExtension)
+}
+
+// AudioPath returns the path of the clip's audio file in directory.
+func (c Clip) AudioPath(directory string) string {
+	return filepath.Join(directory, c.ID+AudioExtension)
+}
+
+// MetadataPath returns the path of the clip's sidecar in directory.
+func (c Clip) MetadataPath(directory string) string {
+	return filepath.Join(directory, c.ID+MetadataExtension)
+}
+
+// ThumbnailPath returns the path of the clip's thumbnail in directory.
+func (c Clip) ThumbnailPath(directory string) string {
+	return filepath.Join(directory, c.ID+ThumbnailExtension)
+}
+
+// activeClip is the clip being recorded.
+type activeClip struct {
+	clip     Clip
+	sequence uint64
+	// motion is set while motion lasts, the clip runs until it ends
+	motion bool
+	// until is the receive time the clip ends at once motion ended
+	until  time.Time
+	peakAt time.Time
+}
+
+// end returns the receive time the clip ends at, as far as known yet.
+func (a *activeClip) end(maxDuration time.Duration) time.Time {
+	limit := a.clip.Start.Add(maxDuration)
+	if a.motion || a.until.After(limit) {
+		return limit
+	}
+	return a.until
+}
+
+// Clipper saves clips of the stream when motion is detected or a clip is
+// requested. The clips start with a pre-roll taken from the DVR buffer and
+// last until the post-roll after the activity passed. The frames are read
+// from the buffer as well, so the clipper never holds up the relay.
+type Clipper struct {
+	Config config.Clips
+
+	timeshifter connectivity.Timeshifter
+	stopping    chan struct{}
+	closeOnce   sync.Once
+	running     sync.WaitGroup
+
+	mutex   sync.Mutex
+	current *activeClip
+	// writing holds the ids of clips whose files are still being written,
+	// the current one and finished ones not closed yet
+	writing map[string]struct{}
+}
+
+func NewClipper(cfg config.Clips, timeshifter connectivity.Timeshifter) (*Clipper, error) {
+	if err := os.MkdirAll(cfg.Directory, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create clips directory: %v", err)
+	}
+
+	c := &Clipper{
+		Config:      cfg,
+		timeshifter: timeshifter,
+		stopping:    make(chan struct{}),
+		writing:     make(map[string]struct{}),
+	}
+	if cfg.MaxAgeDays > 0 {
+		go c.cleanup(time.Duration(cfg.MaxAgeDays) * 24 * time.Hour)
+	}
+
+	fmt.Printf("🎬 Saving clips with %ds pre-roll and %ds post-roll to %s\n", cfg.PreRoll, cfg.PostRoll, cfg.Directory)
+	return c, nil
+}
+
+// HandleMotionEvent starts or extends a clip while motion lasts.
+func (c *Clipper) HandleMotionEvent(event motion.Event) {
+	if c.Config.ManualOnly {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch event.Type {
+	case motion.EventStarted:
+		if c.current == nil {
+			if err := c.startLocked(TriggerMotion, event.Time); err != nil {
+				fmt.Printf("❌ Failed to start clip: %v\n", err)
+				return
+			}
+		}
+		c.current.motion = true
+		if event.Score > c.current.clip.PeakScore {
+			c.current.clip.PeakScore = event.Score
+			c.current.peakAt = event.Time
+		}
+
+	case motion.EventEnded:
+		if c.current == nil {
+			return
+		}
+		c.current.motion = false
+		c.current.clip.PeakScore = max(c.current.clip.PeakScore, event.Score)
+		if until := event.Time.Add(time.Duration(c.Config.PostRoll) * time.Second); until.After(c.current.until) {
+			c.current.until = until
+		}
+	}
+}
+
+// Trigger starts a clip, or extends the one being recorded, lasting at least
+// duration and the post-roll past now.
+func (c *Clipper) Trigger(duration time.Duration) (Clip, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if c.current == nil {
+		if err := c.startLocked(TriggerManual, now); err != nil {
+			return Clip{}, err
+		}
+	}
+
+	until := now.Add(max(duration, time.Duration(c.Config.PostRoll)*time.Second))
+	if until.After(c.current.until) {
+		c.current.until = until
+	}
+	fmt.Printf("🎬 Clip %s triggered manually\n", c.current.clip.ID)
+	return c.current.clip, nil
+}
+
+// startLocked starts recording a clip for a trigger at at, must be called
+// with mutex held.
+func (c *Clipper) startLocked(trigger string, at time.Time) error {
+	select {
+	case <-c.stopping:
+		return errors.New("clipper is closed")
+	default:
+	}
+
+	sequence, receivedAt, ok := c.timeshifter.Seek(at.Add(-time.Duration(c.Config.PreRoll) * time.Second))
+	if !ok {
+		return ErrNothingBuffered
+	}
+
+	clip := Clip{
+		ID:      newSegmentID(c.Config.Directory, receivedAt),
+		Trigger: trigger,
+		Start:   receivedAt,
+		End:     receivedAt,
+	}
+	if err := writeJSON(clip.MetadataPath(c.Config.Directory), clip); err != nil {
+		return err
+	}
+
+	c.current = &activeClip{
+		clip:     clip,
+		sequence: sequence,
+		until:    at,
+		peakAt:   at,
+	}
+	c.writing[clip.ID] = struct{}{}
+	c.running.Add(1)
+	go c.record(c.current)
+
+	fmt.Printf("🎬 Recording clip %s (%s)\n", clip.ID, trigger)
+	return nil
+}
+
+func (c *Clipper) record(active *activeClip) {
+	defer c.running.Done()
+
+	var writer *mediaWriter
+	var last time.Time
+	sequence := active.sequence
+
+	for {
+		select {
+		case <-c.stopping:
+			c.finish(active, writer, last, nil)
+			return
+		default:
+		}
+
+		frames, ok := c.timeshifter.ReadFrames(sequence, clipBatch)
+		if !ok {
+			c.finish(active, writer, last, errors.New("fell behind the DVR buffer"))
+			return
+		}
+
+		if len(frames) == 0 {
+			// The source may be gone, the clip still has to end
+			if c.finished(active, time.Now()) {
+				c.finish(active, writer, last, nil)
+				return
+			}
+			select {
+			case <-c.stopping:
+				c.finish(active, writer, last, nil)
+				return
+			case <-time.After(clipPoll):
+			}
+			continue
+		}
+
+		for _, frame := range frames {
+			sequence = frame.Sequence + 1
+			if c.finished(active, frame.ReceivedAt) {
+				c.finish(active, writer, last, nil)
+				return
+			}
+
+			if frame.Video {
+				if writer != nil && frame.Codec != writer.codec {
+					// The source switched codecs, which one file cannot hold
+					c.finish(active, writer, last, nil)
+					return
+				}
+				if writer == nil {
+					if !frame.Keyframe {
+						continue
+					}
+					var err error
+					writer, err = openMediaWriter(active.clip.VideoPath(c.Config.Directory), active.clip.AudioPath(c.Config.Directory), frame.Frame)
+					if err != nil {
+						c.finish(active, nil, last, err)
+						return
+					}
+				}
+				if err := writer.WriteVideo(frame.Frame); err != nil {
+					c.finish(active, writer, last, err)
+					return
+				}
+				last = frame.ReceivedAt
+			} else if writer != nil && c.Config.Audio {
+				if err := writer.WriteAudio(frame.Frame); err != nil {
+					fmt.Printf("Error writing clip audio: %v\n", err)
+				}
+			}
+		}
+	}
+}
+
+// finished reports whether a frame received at at is past the end of the
+// clip. A finished clip is no longer extended, the next trigger starts a new
+// one.
+func (c *Clipper) finished(active *activeClip, at time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !at.After(active.end(time.Duration(c.Config.MaxDuration) * time.Second)) {
+		return false
+	}
+	if c.current == active {
+		c.current = nil
+	}
+	return true
+}
+
+// finish closes the files of a clip and completes its sidecar, recordErr is
+// the error that ended the recording early.
+func (c *Clipper) finish(active *activeClip, writer *mediaWriter, last time.Time, recordErr error) {
+	c.mutex.Lock()
+	if c.current == active {
+		c.current = nil
+	}
+	clip, peakAt := active.clip, active.peakAt
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		delete(c.writing, clip.ID)
+		c.mutex.Unlock()
+	}()
+
+	directory := c.Config.Directory
+	if writer == nil {
+		// Nothing was recorded
+		if recordErr != nil {
+			fmt.Printf("❌ Clip %s failed: %v\n", clip.ID, recordErr)
+		}
+		os.Remove(clip.MetadataPath(directory))
+		return
+	}
+
+	closeErr := writer.Close()
+	if recordErr == nil {
+		recordErr = closeErr
+	}
+
+	clip.End = last
+	clip.Duration = writer.Duration().Seconds()
+	clip.HasAudio = writer.HasAudio()
+	clip.Size = fileSize(clip.VideoPath(directory)) + fileSize(clip.AudioPath(directory))
+	clip.Complete = recordErr == nil
+
+	if err := writeThumbnail(clip, directory, peakAt.Sub(clip.Start)); err != nil {
+		fmt.Printf("Error writing thumbnail of clip %s: %v\n", clip.ID, err)
+	} else {
+		clip.Thumbnail = true
+	}
+
+	if err := writeJSON(clip.MetadataPath(directory), clip); err != nil {
+		fmt.Printf("❌ Failed to save clip %s: %v\n", clip.ID, err)
+		return
+	}
+	if recordErr != nil {
+		fmt.Printf("⚠️ Clip %s cut short: %v\n", clip.ID, recordErr)
+		return
+	}
+	fmt.Printf("🎬 Saved clip %s (%.0fs, peak %.1f%%)\n", clip.ID, clip.Duration, clip.PeakScore)
+}
+
+// writeThumbnail decodes the frame at offset into the clip as its thumbnail.
+func writeThumbnail(clip Clip, directory string, offset time.Duration) error {
+	offset = min(max(offset, 0), time.Duration(clip.Duration*float64(time.Second)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), thumbnailTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-y",
+		"-loglevel", "error",
+		"-ss", fmt.Sprintf("%.3f", offset.Seconds()),
+		"-i", clip.VideoPath(directory),
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=%d:-2", thumbnailWidth),
+		"-q:v", "5",
+		clip.ThumbnailPath(directory),
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, lastLine(string(output)))
+	}
+	return nil
+}
+
+// cleanup deletes clips older than maxAge every clipCleanup, the clip being
+// recorded is never deleted.
+func (c *Clipper) cleanup(maxAge time.Duration) {
+	ticker := time.NewTicker(clipCleanup)
+	defer ticker.Stop()
+
+	for {
+		clips, err := ListClips(c.Config.Directory)
+		if err != nil {
+			fmt.Printf("Error listing clips: %v\n", err)
+		}
+
+		for _, clip := range clips {
+			if c.Writing(clip.ID) || time.Since(clip.End) <= maxAge {
+				continue
+			}
+			if err := DeleteClip(c.Config.Directory, clip.ID); err != nil {
+				fmt.Printf("Error deleting clip: %v\n", err)
+				continue
+			}
+			fmt.Printf("🗑️ Deleted clip %s, older than the maximum age\n", clip.ID)
+		}
+
+		select {
+		case <-c.stopping:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Writing reports whether the files of clip id are still being written.
+func (c *Clipper) Writing(id string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, ok := c.writing[id]
+	return ok
+}
+
+// Close finishes the clip being recorded.
+func (c *Clipper) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stopping)
+	})
+	c.running.Wait()
+	return nil
+}
+
+// ListClips returns the clips saved in directory, oldest first.
+func ListClips(directory string) ([]Clip, error) {
+	paths, err := filepath.Glob(filepath.Join(directory, "*"+MetadataExtension))
+	if err != nil {
+		return nil, err
+	}
+
+	clips := make([]Clip, 0, len(paths))
+	for _, path := range paths {
+		var clip Clip
+		if err := readJSON(path, &clip); err != nil {
+			fmt.Printf("Skipping clip: %v\n", err)
+			continue
+		}
+		if !clip.Complete {
+			clip.Size = fileSize(clip.VideoPath(directory)) + fileSize(clip.AudioPath(directory))
+		}
+		clips = append(clips, clip)
+	}
+
+	sort.Slice(clips, func(i, j int) bool {
+		return clips[i].Start.Before(clips[j].Start)
+	})
+	return clips, nil
+}
+
+// ClipsBetween returns the clips overlapping the range from to, oldest first.
+func ClipsBetween(directory string, from, to time.Time) ([]Clip, error) {
+	clips, err := ListClips(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	overlapping := clips[:0]
+	for _, clip := range clips {
+		end := clip.End
+		if !clip.Complete {
+			end = time.Now()
+		}
+		if clip.Start.Before(to) && end.After(from) {
+			overlapping = append(overlapping, clip)
+		}
+	}
+	return overlapping, nil
+}
+
+// GetClip returns the clip with the given id.
+func GetClip(directory, id string) (Clip, error) {
+	var clip Clip
+	if !validSegmentID(id) {
+		return clip, ErrClipNotFound
+	}
+	err := readJSON(filepath.Join(directory, id+MetadataExtension), &clip)
+	if os.IsNotExist(err) {
+		return clip, ErrClipNotFound
+	}
+	return clip, err
+}
+
+// DeleteClip removes the files of a clip, the sidecar last so a failed
+// deletion is retried.
+func DeleteClip(directory, id string) error {
+	clip, err := GetClip(directory, id)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range []string{clip.VideoPath(directory), clip.AudioPath(directory), clip.ThumbnailPath(directory), clip.MetadataPath(directory)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// readJSON decodes the file at path into v.
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid metadata %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package recording
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"katkam/internal/infrastructure/connectivity"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	ExportMP4  = "mp4"
+	ExportWebM = "webm"
+
+	MaxExportDuration = 24 * time.Hour
+)
+
+var (
+	ErrNothingRecorded = errors.New("nothing was recorded in the requested range")
+	ErrMixedCodecs     = errors.New("the video codec changed within the requested range, export it in parts")
+)
+
+// SegmentsBetween returns the segments overlapping the range from to, oldest
+// first.
+func SegmentsBetween(directory string, from, to time.Time) ([]Segment, error) {
+	segments, err := ListSegments(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	overlapping := segments[:0]
+	for _, segment := range segments {
+		end := segment.End
+		if !segment.Complete {
+			// Still being written, or cut short by a crash
+			end = time.Now()
+		}
+		if segment.Start.Before(to) && end.After(from) {
+			overlapping = append(overlapping, segment)
+		}
+	}
+	return overlapping, nil
+}
+
+// VideoCodec returns the video codec shared by segments. The concat demuxer
+// cannot stitch segments of different codecs, which happens when the source
+// switched codecs within the range.
+func VideoCodec(segments []Segment) (string, error) {
+	codec := ""
+	for _, segment := range segments {
+		segmentCodec := segment.Codec
+		if segmentCodec == "" {
+			// Recorded before segments had a codec, when all were VP8
+			segmentCodec = connectivity.CodecVP8
+		}
+		if codec != "" && segmentCodec != codec {
+			return "", ErrMixedCodecs
+		}
+		codec = segmentCodec
+	}
+	return codec, nil
+}
+
+// Export writes the range from to as a single file to w, stitching the
+// segments with ffmpeg's concat demuxer. WebM holds VP8 and Opus, MP4 holds
+// H.264 and AAC for players lacking VP8, the video is copied when recorded in
+// that codec and transcoded otherwise. Gaps between segments are left out.
+// Audio is only exported when every segment in the range has some, as the
+// tracks would drift apart otherwise.
+func Export(ctx context.Context, directory string, from, to time.Time, format string, w io.Writer) error {
+	segments, err := SegmentsBetween(directory, from, to)
+	if err != nil {
+		return err
+	}
+	if len(segments) == 0 {
+		return ErrNothingRecorded
+	}
+	codec, err := VideoCodec(segments)
+	if err != nil {
+		return err
+	}
+
+	withAudio := true
+	for _, segment := range segments {
+		withAudio = withAudio && segment.HasAudio
+	}
+
+	lists, err := os.MkdirTemp("", "katkam-export-")
+	if err != nil {
+		return fmt.Errorf("failed to create export directory: %v", err)
+	}
+	defer os.RemoveAll(lists)
+
+	videoList := filepath.Join(lists, "video.txt")
+	if err := writeConcatList(videoList, directory, segments, from, to, Segment.VideoPath); err != nil {
+		return err
+	}
+	args := []string{"-f", "concat", "-safe", "0", "-i", videoList}
+
+	if withAudio {
+		audioList := filepath.Join(lists, "audio.txt")
+		if err := writeConcatList(audioList, directory, segments, from, to, Segment.AudioPath); err != nil {
+			return err
+		}
+		args = append(args, "-f", "concat", "-safe", "0", "-i", audioList, "-map", "0:v:0", "-map", "1:a:0")
+	}
+
+	switch format {
+	case ExportWebM:
+		if codec == connectivity.CodecVP8 {
+			args = append(args, "-c:v", "copy")
+		} else {
+			args = append(args,
+				"-c:v", "libvpx",
+				"-deadline", "good",
+				"-cpu-used", "4",
+				"-crf", "10",
+				"-b:v", "2M",
+			)
+		}
+		if withAudio {
+			args = append(args, "-c:a", "copy")
+		}
+		args = append(args, "-f", "webm")
+	case ExportMP4:
+		if codec == connectivity.CodecH264 {
+			args = append(args, "-c:v", "copy")
+		} else {
+			args = append(args,
+				"-c:v", "libx264",
+				"-preset", "veryfast",
+				"-pix_fmt", "yuv420p",
+			)
+		}
+		if withAudio {
+			args = append(args, "-c:a", "aac", "-b:a", "96k")
+		}
+		// Fragmented so it can be written to a pipe
+		args = append(args, "-movflags", "frag_keyframe+empty_moov", "-f", "mp4")
+	default:
+		return fmt.Errorf("unsupported export format %q", format)
+	}
+	args = append(args, "-")
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stdout = w
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	fmt.Printf("📦 Exporting %d segments: %s\n", len(segments), cmd.String())
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg export failed: %v: %s", err, lastLine(stderr.String()))
+	}
+	return nil
+}
+
+// writeConcatList writes a concat demuxer script for the files of segments,
+// trimming the first and last one to the range.
+func writeConcatList(path, directory string, segments []Segment, from, to time.Time, file func(Segment, string) string) error {
+	var list strings.Builder
+	list.WriteString("ffconcat version 1.0\n")
+
+	for _, segment := range segments {
+		absolute, err := filepath.Abs(file(segment, directory))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(absolute, "'", `'\''`))
+
+		if from.After(segment.Start) {
+			fmt.Fprintf(&list, "inpoint %.3f\n", from.Sub(segment.Start).Seconds())
+		}
+		if !segment.Complete || to.Before(segment.End) {
+			fmt.Fprintf(&list, "outpoint %.3f\n", to.Sub(segment.Start).Seconds())
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(list.String()), 0o600); err != nil {
+		return fmt.Errorf("failed to write concat list: %v", err)
+	}
+	return nil
+}
+
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return lines[len(lines)-1]
+}
@@ -0,0 +1,121 @@
+package recording
+
+import (
+	"fmt"
+	"katkam/internal/infrastructure/connectivity"
+	"katkam/internal/infrastructure/media/ivf"
+	"os"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+)
+
+// mediaWriter writes VP8 or H.264 video to an IVF file and Opus audio to an
+// Ogg file, the audio file is only created once there is audio.
+type mediaWriter struct {
+	audioPath string
+	codec     string
+
+	videoFile *os.File
+	video     *ivf.Writer
+	audio     *oggwriter.OggWriter
+
+	frames    int
+	firstPTS  time.Duration
+	lastPTS   time.Duration
+	audioBase time.Duration
+}
+
+// openMediaWriter creates the video file, keyframe is the first frame to be
+// written.
+func openMediaWriter(videoPath, audioPath string, keyframe connectivity.Frame) (*mediaWriter, error) {
+	fourCC := ivf.FourCC(keyframe.Codec)
+	if fourCC == "" {
+		return nil, fmt.Errorf("unsupported video codec %s", keyframe.Codec)
+	}
+	width, height, _ := connectivity.Dimensions(keyframe.Codec, keyframe.Data)
+
+	file, err := os.Create(videoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create video file: %v", err)
+	}
+
+	video, err := ivf.NewWriter(file, ivf.FileHeader{
+		FourCC:              fourCC,
+		Width:               width,
+		Height:              height,
+		TimebaseNumerator:   1,
+		TimebaseDenominator: 1000,
+	})
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write IVF header: %v", err)
+	}
+
+	return &mediaWriter{
+		audioPath: audioPath,
+		codec:     keyframe.Codec,
+		videoFile: file,
+		video:     video,
+		firstPTS:  keyframe.PTS,
+		lastPTS:   keyframe.PTS,
+	}, nil
+}
+
+func (w *mediaWriter) WriteVideo(frame connectivity.Frame) error {
+	if err := w.video.WriteFrame(ivf.Frame{PTS: frame.PTS - w.firstPTS, Payload: frame.Data}); err != nil {
+		return err
+	}
+
+	w.lastPTS = frame.PTS
+	w.frames++
+	return nil
+}
+
+// WriteAudio appends an Opus packet, the audio file is created with the first
+// one so recordings without audio have none.
+func (w *mediaWriter) WriteAudio(frame connectivity.Frame) error {
+	if w.audio == nil {
+		audio, err := oggwriter.New(w.audioPath, 48000, 2)
+		if err != nil {
+			return fmt.Errorf("failed to create audio file: %v", err)
+		}
+		w.audio = audio
+		w.audioBase = frame.PTS
+	}
+	if frame.PTS < w.audioBase {
+		return nil
+	}
+
+	return w.audio.WriteRTP(&rtp.Packet{
+		Header:  rtp.Header{Timestamp: connectivity.OggTimestamp(frame.PTS - w.audioBase)},
+		Payload: frame.Data,
+	})
+}
+
+// HasAudio reports whether any audio was written.
+func (w *mediaWriter) HasAudio() bool {
+	return w.audio != nil
+}
+
+// Duration returns the time between the first and the last video frame.
+func (w *mediaWriter) Duration() time.Duration {
+	return w.lastPTS - w.firstPTS
+}
+
+func (w *mediaWriter) Close() error {
+	var closeErr error
+	if err := w.video.Close(); err != nil {
+		closeErr = fmt.Errorf("failed to finish video file: %v", err)
+	}
+	if err := w.videoFile.Close(); err != nil && closeErr == nil {
+		closeErr = fmt.Errorf("failed to close video file: %v", err)
+	}
+	if w.audio != nil {
+		if err := w.audio.Close(); err != nil && closeErr == nil {
+			closeErr = fmt.Errorf("failed to close audio file: %v", err)
+		}
+	}
+	return closeErr
+}
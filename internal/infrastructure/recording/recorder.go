@@ -0,0 +1,344 @@
+package recording
+
+import (
+	"fmt"
+	"katkam/internal/config"
+	"katkam/internal/infrastructure/connectivity"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// recorderQueueSize holds a few seconds of frames while the disk is busy
+const recorderQueueSize = 600
+
+type operationKind int
+
+const (
+	operationVideo operationKind = iota
+	operationAudio
+	operationSplit
+)
+
+type operation struct {
+	kind  operationKind
+	frame connectivity.Frame
+}
+
+// Recorder writes the relayed stream to segments of a fixed duration. Segments
+// start on a keyframe, a new one is started whenever the source disconnects
+// or restarts its timeline. Frames are written by a goroutine of its own, so
+// a slow disk never holds up the relay.
+type Recorder struct {
+	Config config.Recording
+
+	operations chan operation
+	stopping   chan struct{}
+	stopped    chan struct{}
+	closeOnce  sync.Once
+
+	// dropped is set when the queue overflowed, the following frames cannot
+	// be decoded until the next keyframe
+	dropped atomic.Bool
+
+	retention        *retention
+	retentionTrigger chan struct{}
+	currentID        atomic.Value
+
+	// motion is set while motion is detected, the segments recorded
+	// meanwhile are marked. motionStarted wakes the run goroutine to mark
+	// the current segment without ever blocking the detector.
+	motion        atomic.Bool
+	motionStarted chan struct{}
+
+	// Owned by the run goroutine
+	current          *segmentWriter
+	awaitingKeyframe bool
+}
+
+func NewRecorder(cfg config.Recording) (*Recorder, error) {
+	if err := os.MkdirAll(cfg.Directory, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %v", err)
+	}
+
+	r := &Recorder{
+		Config:           cfg,
+		operations:       make(chan operation, recorderQueueSize),
+		stopping:         make(chan struct{}),
+		stopped:          make(chan struct{}),
+		retentionTrigger: make(chan struct{}, 1),
+		motionStarted:    make(chan struct{}, 1),
+	}
+	r.currentID.Store("")
+
+	maxAge := time.Duration(cfg.MaxAgeDays) * 24 * time.Hour
+	maxBytes := cfg.MaxSizeMB * 1024 * 1024
+	r.retention = newRetention(cfg.Directory, maxAge, maxBytes, r.CurrentSegment)
+
+	go r.run()
+	go r.retention.run(r.retentionTrigger, r.stopping)
+
+	fmt.Printf("⏺️ Recording %d second segments to %s\n", cfg.SegmentDuration, cfg.Directory)
+	return r, nil
+}
+
+func (r *Recorder) SendVideoFrame(frame connectivity.Frame) {
+	r.enqueue(operation{kind: operationVideo, frame: frame})
+}
+
+func (r *Recorder) SendAudioFrame(frame connectivity.Frame) {
+	if r.Config.Audio {
+		r.enqueue(operation{kind: operationAudio, frame: frame})
+	}
+}
+
+func (r *Recorder) SourceConnected() {}
+
+// SourceDisconnected finishes the current segment, the next one starts with
+// the first keyframe of the returning source.
+func (r *Recorder) SourceDisconnected() {
+	r.enqueue(operation{kind: operationSplit})
+}
+
+// SetMotion reports whether motion is detected, the segment being recorded
+// and the ones started while motion lasts are marked.
+func (r *Recorder) SetMotion(active bool) {
+	r.motion.Store(active)
+	if active {
+		select {
+		case r.motionStarted <- struct{}{}:
+		default:
+			// A mark is already pending
+		}
+	}
+}
+
+// enqueue hands op to the run goroutine. Frames are dropped when the queue is
+// full, splits wait for room instead: losing a split would append the
+// returning source's timeline to the segment of the previous one.
+func (r *Recorder) enqueue(op operation) {
+	if op.kind == operationSplit {
+		select {
+		case <-r.stopping:
+		case r.operations <- op:
+		}
+		return
+	}
+
+	select {
+	case <-r.stopping:
+	case r.operations <- op:
+	default:
+		if op.kind == operationVideo && !r.dropped.Swap(true) {
+			fmt.Println("⚠️ Recorder falls behind, dropping frames until the next keyframe")
+		}
+	}
+}
+
+func (r *Recorder) run() {
+	defer close(r.stopped)
+
+	for {
+		select {
+		case <-r.stopping:
+			r.closeSegment()
+			return
+		case <-r.motionStarted:
+			if r.motion.Load() {
+				r.markMotion()
+			}
+		case op := <-r.operations:
+			switch op.kind {
+			case operationVideo:
+				r.writeVideo(op.frame)
+			case operationAudio:
+				if r.current != nil {
+					if err := r.current.WriteAudio(op.frame); err != nil {
+						fmt.Printf("Error recording audio: %v\n", err)
+					}
+				}
+			case operationSplit:
+				r.closeSegment()
+			}
+		}
+	}
+}
+
+func (r *Recorder) writeVideo(frame connectivity.Frame) {
+	if r.current != nil {
+		elapsed := frame.PTS - r.current.firstPTS
+		switch {
+		case frame.PTS < r.current.lastPTS:
+			// The source restarted its timeline
+			r.closeSegment()
+		case frame.Codec != r.current.segment.Codec:
+			// A segment holds a single codec, the new one starts on its
+			// first keyframe
+			r.closeSegment()
+		case frame.Keyframe && elapsed >= time.Duration(r.Config.SegmentDuration)*time.Second:
+			r.closeSegment()
+		}
+	}
+
+	if r.dropped.Swap(false) {
+		r.awaitingKeyframe = true
+	}
+	if r.current == nil || r.awaitingKeyframe {
+		if !frame.Keyframe {
+			return
+		}
+		r.awaitingKeyframe = false
+	}
+
+	if r.current == nil {
+		// The id is claimed before the sidecar exists, so retention never
+		// takes the new segment for a finished one
+		start := time.Now()
+		id := newSegmentID(r.Config.Directory, start)
+		r.currentID.Store(id)
+
+		segment, err := openSegment(r.Config.Directory, id, start, frame)
+		if err != nil {
+			r.currentID.Store("")
+			fmt.Printf("❌ Failed to start recording segment: %v\n", err)
+			return
+		}
+		fmt.Printf("⏺️ Recording segment %s\n", id)
+		r.current = segment
+		if r.motion.Load() {
+			r.markMotion()
+		}
+	}
+
+	if err := r.current.WriteVideo(frame); err != nil {
+		fmt.Printf("❌ Error recording video, starting a new segment: %v\n", err)
+		r.closeSegment()
+	}
+}
+
+func (r *Recorder) closeSegment() {
+	if r.current == nil {
+		return
+	}
+
+	if err := r.current.Close(); err != nil {
+		fmt.Printf("Error finishing recording segment %s: %v\n", r.current.segment.ID, err)
+	} else {
+		fmt.Printf("⏹️ Recorded segment %s (%.0fs)\n", r.current.segment.ID, r.current.segment.Duration)
+	}
+	r.current = nil
+	r.currentID.Store("")
+
+	// Make room for the next segment right away
+	select {
+	case r.retentionTrigger <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Recorder) markMotion() {
+	if r.current == nil || r.current.segment.Motion {
+		return
+	}
+
+	r.current.segment.Motion = true
+	_, err := updateMetadata(r.Config.Directory, r.current.segment.ID, func(segment *Segment) {
+		segment.Motion = true
+	})
+	if err != nil {
+		fmt.Printf("Error marking motion in segment %s: %v\n", r.current.segment.ID, err)
+	}
+}
+
+// CurrentSegment returns the id of the segment being written, if any.
+func (r *Recorder) CurrentSegment() string {
+	return r.currentID.Load().(string)
+}
+
+// Usage returns the storage taken by the recordings.
+func (r *Recorder) Usage() Usage {
+	return r.retention.Usage()
+}
+
+// Status reports the recorder in the status API.
+func (r *Recorder) Status() map[string]interface{} {
+	return map[string]interface{}{
+		"recording": map[string]interface{}{
+			"current_segment": r.CurrentSegment(),
+			"usage":           r.Usage(),
+		},
+	}
+}
+
+// Directory holds the recorded segments.
+func (r *Recorder) Directory() string {
+	return r.Config.Directory
+}
+
+// Close finishes the segment being written.
+func (r *Recorder) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.stopping)
+	})
+	<-r.stopped
+	return nil
+}
+
+// segmentWriter writes the files of a single segment.
+type segmentWriter struct {
+	*mediaWriter
+
+	directory string
+	segment   Segment
+}
+
+// openSegment starts segment id at start, with keyframe as its first frame.
+func openSegment(directory, id string, start time.Time, keyframe connectivity.Frame) (*segmentWriter, error) {
+	segment := Segment{
+		ID:    id,
+		Start: start,
+		End:   start,
+		Codec: keyframe.Codec,
+	}
+	segment.Width, segment.Height, _ = connectivity.Dimensions(keyframe.Codec, keyframe.Data)
+
+	media, err := openMediaWriter(segment.VideoPath(directory), segment.AudioPath(directory), keyframe)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &segmentWriter{
+		mediaWriter: media,
+		directory:   directory,
+		segment:     segment,
+	}
+	if err := writeMetadata(directory, segment); err != nil {
+		media.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Close finishes the files and the sidecar of the segment.
+func (w *segmentWriter) Close() error {
+	closeErr := w.mediaWriter.Close()
+
+	// Only the fields owned by the recorder are updated, the segment may
+	// have been marked in the meantime
+	duration := w.Duration()
+	_, err := updateMetadata(w.directory, w.segment.ID, func(segment *Segment) {
+		segment.Duration = duration.Seconds()
+		segment.End = segment.Start.Add(duration)
+		segment.Frames = w.frames
+		segment.HasAudio = w.HasAudio()
+		segment.Size = segment.fileSize(w.directory)
+		segment.Complete = closeErr == nil
+	})
+	if err != nil && closeErr == nil {
+		closeErr = err
+	}
+	w.segment.Duration = duration.Seconds()
+	return closeErr
+}
@@ -0,0 +1,160 @@
+package recording
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const retentionInterval = time.Minute
+
+// Usage describes the storage taken by recordings.
+type Usage struct {
+	Segments     int       `json:"segments"`
+	KeptSegments int       `json:"kept_segments"`
+	Bytes        int64     `json:"bytes"`
+	KeptBytes    int64     `json:"kept_bytes"`
+	Oldest       time.Time `json:"oldest"`
+	DiskFree     uint64    `json:"disk_free"`
+	DiskTotal    uint64    `json:"disk_total"`
+	CheckedAt    time.Time `json:"checked_at"`
+}
+
+// retention deletes the oldest segments once they are older than maxAge or
+// the recordings exceed maxBytes. Kept segments and the segment being written
+// are never deleted, they still count towards the size though.
+type retention struct {
+	directory string
+	maxAge    time.Duration
+	maxBytes  int64
+	current   func() string
+
+	mutex sync.RWMutex
+	usage Usage
+}
+
+func newRetention(directory string, maxAge time.Duration, maxBytes int64, current func() string) *retention {
+	return &retention{
+		directory: directory,
+		maxAge:    maxAge,
+		maxBytes:  maxBytes,
+		current:   current,
+	}
+}
+
+// run enforces the policy every retentionInterval and whenever trigger
+// fires, until stop is closed.
+func (r *retention) run(trigger <-chan struct{}, stop <-chan struct{}) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+
+	r.enforce()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-trigger:
+		}
+		r.enforce()
+	}
+}
+
+func (r *retention) enforce() {
+	segments, err := ListSegments(r.directory)
+	if err != nil {
+		fmt.Printf("Error listing recordings: %v\n", err)
+		return
+	}
+
+	var total int64
+	for _, segment := range segments {
+		total += segment.Size
+	}
+
+	// Oldest first, so both limits delete from the start
+	current := r.current()
+	remaining := segments[:0]
+	for _, segment := range segments {
+		tooOld := r.maxAge > 0 && time.Since(segment.End) > r.maxAge
+		tooBig := r.maxBytes > 0 && total > r.maxBytes
+		if segment.Kept || segment.ID == current || (!tooOld && !tooBig) {
+			remaining = append(remaining, segment)
+			continue
+		}
+
+		deleted, err := r.delete(segment.ID)
+		if err != nil {
+			fmt.Printf("Error deleting recording: %v\n", err)
+		}
+		if !deleted {
+			remaining = append(remaining, segment)
+			continue
+		}
+		total -= segment.Size
+
+		reason := "older than the maximum age"
+		if !tooOld {
+			reason = "over the size limit"
+		}
+		fmt.Printf("🗑️ Deleted recording %s, %s\n", segment.ID, reason)
+	}
+
+	if r.maxBytes > 0 && total > r.maxBytes {
+		fmt.Printf("⚠️ Recordings take %d bytes, over the limit of %d, as kept segments are never deleted\n", total, r.maxBytes)
+	}
+
+	r.updateUsage(remaining)
+}
+
+// delete removes segment id unless it was kept or became the current segment
+// since it was listed. The sidecar is read again under the metadata lock, so
+// a segment kept through the API meanwhile survives.
+func (r *retention) delete(id string) (bool, error) {
+	metadataMutex.Lock()
+	defer metadataMutex.Unlock()
+
+	segment, err := readMetadata(filepath.Join(r.directory, id+MetadataExtension))
+	if err != nil {
+		return false, err
+	}
+	if segment.Kept || segment.ID == r.current() {
+		return false, nil
+	}
+	if err := deleteSegment(r.directory, segment); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *retention) updateUsage(segments []Segment) {
+	usage := Usage{CheckedAt: time.Now()}
+	for _, segment := range segments {
+		usage.Segments++
+		usage.Bytes += segment.Size
+		if segment.Kept {
+			usage.KeptSegments++
+			usage.KeptBytes += segment.Size
+		}
+	}
+	if len(segments) > 0 {
+		usage.Oldest = segments[0].Start
+	}
+
+	free, total, err := diskSpace(r.directory)
+	if err == nil {
+		usage.DiskFree, usage.DiskTotal = free, total
+	}
+
+	r.mutex.Lock()
+	r.usage = usage
+	r.mutex.Unlock()
+}
+
+// Usage returns the storage usage found by the last run.
+func (r *retention) Usage() Usage {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.usage
+}
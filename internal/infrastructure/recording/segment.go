@@ -0,0 +1,214 @@
+// Package recording persists the relayed stream to disk, continuously as time
+// based segments and as event clips.
+//
+// A segment is a set of files sharing a base name derived from its start
+// time: the VP8 or H.264 video as IVF, the Opus audio (if any) as Ogg and a JSON
+// sidecar with the segment's metadata. Clips are stored the same way in a
+// directory of their own, with a JPEG thumbnail added.
+package recording
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	VideoExtension    = ".ivf"
+	AudioExtension    = ".ogg"
+	MetadataExtension = ".json"
+
+	// segmentNameLayout names segments after their local start time
+	segmentNameLayout = "2006-01-02_15-04-05"
+)
+
+// Segment is the sidecar metadata of a recorded segment.
+type Segment struct {
+	ID       string    `json:"id"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration float64   `json:"duration"` // seconds
+	Frames   int       `json:"frames"`
+	Codec    string    `json:"codec"`
+	Width    uint16    `json:"width"`
+	Height   uint16    `json:"height"`
+	HasAudio bool      `json:"has_audio"`
+	Size     int64     `json:"size"` // bytes, of all files of the segment
+	// Complete is false while the segment is being written, or when the
+	// recorder stopped before it could finish the segment
+	Complete bool `json:"complete"`
+	// Kept segments are never deleted by the retention policy
+	Kept bool `json:"kept"`
+	// Motion is set when motion was detected while the segment was recorded
+	Motion bool `json:"motion"`
+}
+
+var ErrSegmentNotFound = errors.New("segment not found")
+
+// metadataMutex serialises read-modify-write cycles of sidecars, which are
+// updated by the recorder and through the API
+var metadataMutex sync.Mutex
+
+// VideoPath returns the path of the segment's video file in directory.
+func (s Segment) VideoPath(directory string) string {
+	return filepath.Join(directory, s.ID+VideoExtension)
+}
+
+// AudioPath returns the path of the segment's audio file in directory.
+func (s Segment) AudioPath(directory string) string {
+	return filepath.Join(directory, s.ID+AudioExtension)
+}
+
+// MetadataPath returns the path of the segment's sidecar in directory.
+func (s Segment) MetadataPath(directory string) string {
+	return filepath.Join(directory, s.ID+MetadataExtension)
+}
+
+// newSegmentID names a segment starting at start, adding a suffix if a
+// segment with that name already exists in directory.
+func newSegmentID(directory string, start time.Time) string {
+	id := start.Format(segmentNameLayout)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(filepath.Join(directory, id+MetadataExtension)); os.IsNotExist(err) {
+			return id
+		}
+		id = fmt.Sprintf("%s_%d", start.Format(segmentNameLayout), i)
+	}
+}
+
+// writeMetadata replaces the sidecar of segment atomically.
+func writeMetadata(directory string, segment Segment) error {
+	metadataMutex.Lock()
+	defer metadataMutex.Unlock()
+	return writeMetadataLocked(directory, segment)
+}
+
+func writeMetadataLocked(directory string, segment Segment) error {
+	return writeJSON(segment.MetadataPath(directory), segment)
+}
+
+// writeJSON replaces the file at path with v encoded as JSON, atomically.
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %v", err)
+	}
+
+	temporary := path + ".tmp"
+	if err := os.WriteFile(temporary, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write metadata: %v", err)
+	}
+	if err := os.Rename(temporary, path); err != nil {
+		return fmt.Errorf("failed to write metadata: %v", err)
+	}
+	return nil
+}
+
+// updateMetadata applies update to the sidecar of segment id.
+func updateMetadata(directory, id string, update func(segment *Segment)) (Segment, error) {
+	metadataMutex.Lock()
+	defer metadataMutex.Unlock()
+
+	segment, err := readMetadata(filepath.Join(directory, id+MetadataExtension))
+	if err != nil {
+		return Segment{}, err
+	}
+
+	update(&segment)
+	return segment, writeMetadataLocked(directory, segment)
+}
+
+func readMetadata(path string) (Segment, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Segment{}, ErrSegmentNotFound
+	}
+	if err != nil {
+		return Segment{}, err
+	}
+
+	var segment Segment
+	if err := json.Unmarshal(data, &segment); err != nil {
+		return Segment{}, fmt.Errorf("invalid segment metadata %s: %v", path, err)
+	}
+	return segment, nil
+}
+
+// ListSegments returns the segments recorded in directory, oldest first.
+// Sizes of segments still being written are taken from their files.
+func ListSegments(directory string) ([]Segment, error) {
+	paths, err := filepath.Glob(filepath.Join(directory, "*"+MetadataExtension))
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]Segment, 0, len(paths))
+	for _, path := range paths {
+		segment, err := readMetadata(path)
+		if err != nil {
+			fmt.Printf("Skipping segment: %v\n", err)
+			continue
+		}
+		if !segment.Complete {
+			segment.Size = segment.fileSize(directory)
+		}
+		segments = append(segments, segment)
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Start.Before(segments[j].Start)
+	})
+	return segments, nil
+}
+
+// GetSegment returns the segment with the given id.
+func GetSegment(directory, id string) (Segment, error) {
+	if !validSegmentID(id) {
+		return Segment{}, ErrSegmentNotFound
+	}
+	return readMetadata(filepath.Join(directory, id+MetadataExtension))
+}
+
+// SetKept marks a segment as kept, or hands it back to the retention policy.
+func SetKept(directory, id string, kept bool) (Segment, error) {
+	if !validSegmentID(id) {
+		return Segment{}, ErrSegmentNotFound
+	}
+	return updateMetadata(directory, id, func(segment *Segment) {
+		segment.Kept = kept
+	})
+}
+
+// deleteSegment removes the files of segment, the sidecar last so a failed
+// deletion is retried.
+func deleteSegment(directory string, segment Segment) error {
+	for _, path := range []string{segment.VideoPath(directory), segment.AudioPath(directory), segment.MetadataPath(directory)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+func (s Segment) fileSize(directory string) int64 {
+	return fileSize(s.VideoPath(directory)) + fileSize(s.AudioPath(directory))
+}
+
+// validSegmentID rejects ids that would address files outside the directory.
+func validSegmentID(id string) bool {
+	return id != "" && id != "." && id != ".." && !strings.ContainsAny(id, `/\`)
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
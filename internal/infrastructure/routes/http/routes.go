@@ -8,17 +8,58 @@ import (
 type HttpRouter struct {
 	authHandler  *handlers.AuthHandler
 	relayHandler *handlers.RelayHandler
+	mediaHandler *handlers.MediaHandler
+
+	recordingsHandler *handlers.RecordingsHandler
+	motionHandler     *handlers.MotionHandler
+	clipsHandler      *handlers.ClipsHandler
 }
 
-func NewHttpRouter(authHandler *handlers.AuthHandler, relayHandler *handlers.RelayHandler) *HttpRouter {
+func NewHttpRouter(authHandler *handlers.AuthHandler, relayHandler *handlers.RelayHandler, mediaHandler *handlers.MediaHandler, recordingsHandler *handlers.RecordingsHandler, motionHandler *handlers.MotionHandler, clipsHandler *handlers.ClipsHandler) *HttpRouter {
 	return &HttpRouter{
 		authHandler:  authHandler,
 		relayHandler: relayHandler,
+		mediaHandler: mediaHandler,
+
+		recordingsHandler: recordingsHandler,
+		motionHandler:     motionHandler,
+		clipsHandler:      clipsHandler,
 	}
 }
 
 func (h *HttpRouter) SetupRoutes() {
 	http.HandleFunc("/relay/start", h.relayHandler.Start)
+	http.HandleFunc("/api/camera/status", h.authHandler.RequireJWT(h.relayHandler.Status))
+	http.HandleFunc("/api/health", h.relayHandler.Health)
+	http.HandleFunc("/api/camera/snapshot.jpg", h.authHandler.RequireJWT(h.mediaHandler.Snapshot))
+	http.HandleFunc("/api/camera/mjpeg", h.authHandler.RequireJWT(h.mediaHandler.MJPEG))
+
+	// WHIP endpoint and its session resources
+	http.HandleFunc("/whip", h.authHandler.RequireJWT(h.relayHandler.HandleWHIP))
+	http.HandleFunc("/whip/{id}", h.authHandler.RequireJWT(h.relayHandler.HandleWHIP))
+
+	// WHEP endpoint and its session resources
+	http.HandleFunc("/whep", h.authHandler.RequireJWT(h.relayHandler.HandleWHEP))
+	http.HandleFunc("/whep/{id}", h.authHandler.RequireJWT(h.relayHandler.HandleWHEP))
+
+	http.HandleFunc("/hls/{file}", h.authHandler.RequireJWT(h.mediaHandler.HLS))
+
+	// Recorded segments, exports stitch them to a single file
+	http.HandleFunc("/api/recordings", h.authHandler.RequireJWT(h.recordingsHandler.List))
+	http.HandleFunc("/api/recordings/export", h.authHandler.RequireJWT(h.recordingsHandler.Export))
+	http.HandleFunc("/api/recordings/{id}", h.authHandler.RequireJWT(h.recordingsHandler.Get))
+	http.HandleFunc("/api/recordings/{id}/video", h.authHandler.RequireJWT(h.recordingsHandler.Video))
+	http.HandleFunc("/api/recordings/{id}/audio", h.authHandler.RequireJWT(h.recordingsHandler.Audio))
+	http.HandleFunc("/api/recordings/{id}/keep", h.authHandler.RequireJWT(h.recordingsHandler.Keep))
+
+	http.HandleFunc("/api/motion/events", h.authHandler.RequireJWT(h.motionHandler.Events))
+
+	// Event clips, POST to /api/clips triggers one
+	http.HandleFunc("/api/clips", h.authHandler.RequireJWT(h.clipsHandler.Clips))
+	http.HandleFunc("/api/clips/{id}", h.authHandler.RequireJWT(h.clipsHandler.Clip))
+	http.HandleFunc("/api/clips/{id}/video", h.authHandler.RequireJWT(h.clipsHandler.Video))
+	http.HandleFunc("/api/clips/{id}/audio", h.authHandler.RequireJWT(h.clipsHandler.Audio))
+	http.HandleFunc("/api/clips/{id}/thumbnail.jpg", h.authHandler.RequireJWT(h.clipsHandler.Thumbnail))
 
 	http.HandleFunc("/auth/login", h.authHandler.Login)
 	http.HandleFunc("/auth/logout", h.authHandler.Logout)
@@ -3,12 +3,15 @@ package main
 import (
 	"fmt"
 	"katkam/internal/auth"
-	"katkam/internal/config"
+	cfg "katkam/internal/config"
 	"katkam/internal/handlers"
 	"katkam/internal/infrastructure/connectivity"
 	"katkam/internal/infrastructure/connectivity/receivers"
 	"katkam/internal/infrastructure/connectivity/relay"
 	"katkam/internal/infrastructure/connectivity/senders"
+	"katkam/internal/infrastructure/connectivity/sinks"
+	"katkam/internal/infrastructure/motion"
+	"katkam/internal/infrastructure/recording"
 	repo "katkam/internal/infrastructure/repository"
 	internal_http "katkam/internal/infrastructure/routes/http"
 	internal_websocket "katkam/internal/infrastructure/routes/websocket"
@@ -24,7 +27,7 @@ func main() {
 		panic(err)
 	}
 
-	config, err := config.LoadConfig()
+	config, err := cfg.LoadConfig()
 	if err != nil {
 		panic(err)
 	}
@@ -33,13 +36,75 @@ func main() {
 	userRepo := repo.NewUserRepository(config.Users)
 
 	var receiver connectivity.Receiver
-	if config.Server.UseDirectCamera {
-		receiver = receivers.NewCamera()
-	} else {
+	switch config.Server.Receiver {
+	case cfg.ReceiverCamera:
+		receiver = receivers.NewCamera(config.Camera)
+	case cfg.ReceiverTestPattern:
+		receiver = receivers.NewTestPattern(config.TestPattern)
+	case cfg.ReceiverFile:
+		receiver = receivers.NewFilePlayback(config.Playback)
+	default:
 		receiver = receivers.NewWebRTCReceiver()
 	}
 	sender := senders.NewWebRTCSender()
+	if config.Talkback.Enabled {
+		if err := sender.EnableTalkback(config.Talkback); err != nil {
+			panic(err)
+		}
+	}
 	relay := relay.NewWebRTCRelay(receiver, sender)
+	var dvr connectivity.Timeshifter
+	if config.DVR.Enabled {
+		dvr = relay.EnableDVR(config.DVR)
+	}
+	// Idle unless viewers need the video in another codec than the source's
+	relay.AddSink(sinks.NewTranscoder(sender.NeedsTranscoding, sender.SendTranscodedVideoFrame))
+
+	var hls *sinks.HLS
+	if config.HLS.Enabled {
+		hls, err = sinks.NewHLS(config.HLS)
+		if err != nil {
+			panic(err)
+		}
+		relay.AddSink(hls)
+	}
+
+	var mjpeg *sinks.MJPEG
+	if config.MJPEG.Enabled {
+		mjpeg = sinks.NewMJPEG(config.MJPEG)
+		relay.AddSink(mjpeg)
+	}
+
+	var recorder *recording.Recorder
+	if config.Recording.Enabled {
+		recorder, err = recording.NewRecorder(config.Recording)
+		if err != nil {
+			panic(err)
+		}
+		relay.AddSink(recorder)
+	}
+
+	var clipper *recording.Clipper
+	if config.Clips.Enabled {
+		clipper, err = recording.NewClipper(config.Clips, dvr)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var detector *motion.Detector
+	if config.Motion.Enabled {
+		detector = motion.NewDetector(config.Motion)
+		if recorder != nil {
+			detector.AddEventHandler(func(event motion.Event) {
+				recorder.SetMotion(event.Type == motion.EventStarted)
+			})
+		}
+		if clipper != nil {
+			detector.AddEventHandler(clipper.HandleMotionEvent)
+		}
+		relay.AddSink(sinks.NewMotion(detector))
+	}
 	// relay.Start()
 
 	// features
@@ -48,9 +113,13 @@ func main() {
 	// handlers
 	authHandler := handlers.NewAuthHandler(authorizer)
 	relayHandler := handlers.NewRelayHandler(relay)
+	mediaHandler := handlers.NewMediaHandler(hls, mjpeg)
+	recordingsHandler := handlers.NewRecordingsHandler(config.Recording.Directory)
+	motionHandler := handlers.NewMotionHandler(detector)
+	clipsHandler := handlers.NewClipsHandler(config.Clips.Directory, clipper)
 
 	// routes
-	httpRouter := internal_http.NewHttpRouter(authHandler, relayHandler)
+	httpRouter := internal_http.NewHttpRouter(authHandler, relayHandler, mediaHandler, recordingsHandler, motionHandler, clipsHandler)
 	websocketRouter := internal_websocket.NewWebSocketRouter(relayHandler)
 	httpRouter.SetupRoutes()
 	websocketRouter.SetupRoutes()
@@ -60,7 +129,23 @@ func main() {
 	fmt.Printf("Starting camera streaming server on port %s\n", port)
 	fmt.Printf("Access camera stream at: http://localhost%s\n", port)
 	fmt.Printf("Camera control: http://localhost%s/api/camera/status\n", port)
+	fmt.Printf("Health check: http://localhost%s/api/health\n", port)
 	fmt.Printf("Camera WebSocket: ws://localhost%s/ws/sender\n", port)
+	fmt.Printf("WHIP publishing: http://localhost%s/whip\n", port)
+	fmt.Printf("WHEP playback: http://localhost%s/whep\n", port)
+	if hls != nil {
+		fmt.Printf("HLS playback: http://localhost%s/hls/index.m3u8\n", port)
+	}
+	if mjpeg != nil {
+		fmt.Printf("Snapshots: http://localhost%s/api/camera/snapshot.jpg\n", port)
+	}
+	fmt.Printf("Recordings: http://localhost%s/api/recordings\n", port)
+	if detector != nil {
+		fmt.Printf("Motion events: http://localhost%s/api/motion/events\n", port)
+	}
+	if clipper != nil {
+		fmt.Printf("Clips: http://localhost%s/api/clips\n", port)
+	}
 
 	log.Fatal(http.ListenAndServe(port, nil))
 }
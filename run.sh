@@ -46,6 +46,7 @@ echo "────────────────────────
 echo "📹 Backend (Camera): http://localhost:8080"
 echo "🖥️  Frontend (UI):   http://localhost:8081"
 echo "📊 Camera Status:    http://localhost:8080/api/camera/status"
+echo "💓 Camera Health:    http://localhost:8080/api/health"
 echo "🔧 UI Health:        http://localhost:8081/health"
 echo ""
 echo "Open http://localhost:8081 in your browser to view the camera stream!"